@@ -0,0 +1,172 @@
+package btcbuilder
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/conformal/btcwire"
+)
+
+// TxStatus is what became of a tx handed to a Broadcaster.
+type TxStatus int
+
+const (
+	TxSent    TxStatus = iota // The node accepted the tx
+	TxKnown                   // The node already had the tx in its mempool or chain
+	TxFailed                  // The node rejected the tx or could not be reached
+	TxSkipped                 // A parent was not broadcast so the tx was never sent
+)
+
+func (s TxStatus) String() string {
+	switch s {
+	case TxSent:
+		return "sent"
+	case TxKnown:
+		return "known"
+	case TxFailed:
+		return "failed"
+	case TxSkipped:
+		return "skipped"
+	}
+	return fmt.Sprintf("TxStatus(%d)", int(s))
+}
+
+// TxResult reports how broadcasting a single tx went.
+type TxResult struct {
+	Tx     *btcwire.MsgTx
+	Hash   *btcwire.ShaHash
+	Status TxStatus
+	Tries  int   // The number of sendrawtransaction calls made
+	Err    error // Why the tx failed or was skipped
+}
+
+// Ok reports whether the node has the tx.
+func (r *TxResult) Ok() bool {
+	return r.Status == TxSent || r.Status == TxKnown
+}
+
+// A Broadcaster sends a Package of txs through the params' rpc client with
+// parents ahead of their children. Failures to reach the node are retried,
+// anything the node answers with is not.
+type Broadcaster struct {
+	Params   BuilderParams
+	MaxTries int           // The most sends attempted per tx
+	Backoff  time.Duration // The wait after the first failed try, doubled after each one
+}
+
+func NewBroadcaster(params BuilderParams) *Broadcaster {
+	b := Broadcaster{
+		Params:   params,
+		MaxTries: 5,
+		Backoff:  500 * time.Millisecond,
+	}
+	return &b
+}
+
+// Broadcast sends every tx in pkg and returns a result for each in the order
+// they were sent. The children of a tx that fails are skipped. Txs that did
+// not go out are reverted in the package's source, usually the params' Cache,
+// so that later builders do not pick their txouts.
+func (b *Broadcaster) Broadcast(pkg *Package) []*TxResult {
+	byIdx := make([]*TxResult, pkg.Len())
+	results := make([]*TxResult, 0, pkg.Len())
+	defer b.revertFailed(pkg, byIdx)
+	for _, idx := range pkg.order() {
+		res := &TxResult{Tx: pkg.txs[idx], Hash: pkg.hashes[idx]}
+		byIdx[idx] = res
+		results = append(results, res)
+
+		for _, parent := range pkg.Parents(idx) {
+			if !byIdx[parent].Ok() {
+				res.Status = TxSkipped
+				res.Err = fmt.Errorf("Parent tx %s was not broadcast", pkg.hashes[parent])
+				break
+			}
+		}
+		if res.Status == TxSkipped {
+			releaseTx(res.Tx, b.Params)
+			continue
+		}
+		b.send(res)
+	}
+	return results
+}
+
+// Send builds each of builders into a package and broadcasts it. A builder
+// that fails stops everything before any tx is sent.
+func (b *Broadcaster) Send(builders ...TxBuilder) ([]*TxResult, error) {
+	pkg := NewPackage(b.Params)
+	for _, builder := range builders {
+		if _, err := pkg.Build(builder); err != nil {
+			if rbErr := pkg.Rollback(); rbErr != nil && b.Params.Logger != nil {
+				b.Params.Logger.Printf("Could not roll back package: %s\n", rbErr)
+			}
+			return nil, &BuildError{Err: err}
+		}
+	}
+	return b.Broadcast(pkg), nil
+}
+
+// revertFailed takes the txs the node does not have out of the package's
+// source. Txs that could not be sent at all may have reached the node anyway,
+// so rather than guess the params' Cache is refreshed from it.
+func (b *Broadcaster) revertFailed(pkg *Package, byIdx []*TxResult) {
+	failed := make([]int, 0)
+	unknown := false
+	for idx, res := range byIdx {
+		if res == nil || res.Ok() {
+			continue
+		}
+		if _, ok := res.Err.(*TransportError); ok {
+			unknown = true
+			continue
+		}
+		failed = append(failed, idx)
+	}
+	if len(failed) > 0 {
+		if err := pkg.revert(failed); err != nil && b.Params.Logger != nil {
+			b.Params.Logger.Printf("Could not revert failed txs: %s\n", err)
+		}
+	}
+	if unknown && b.Params.Cache != nil {
+		if err := b.Params.Cache.Refresh(); err != nil && b.Params.Logger != nil {
+			b.Params.Logger.Printf("Could not refresh the cache: %s\n", err)
+		}
+	}
+}
+
+// send pushes a single tx to the node, retrying while the node is unreachable.
+func (b *Broadcaster) send(res *TxResult) {
+	wait := b.Backoff
+	for {
+		res.Tries++
+		_, err := b.Params.Client.SendRawTransaction(res.Tx, false)
+		if err == nil {
+			res.Status = TxSent
+			b.Params.applyTx(res.Tx)
+			return
+		}
+
+		sendErr := sendError(err, res.Hash)
+		switch sendErr.(type) {
+		case *AlreadyInChainError:
+			res.Status = TxKnown
+			b.Params.applyTx(res.Tx)
+			return
+		case *TransportError:
+			if res.Tries < b.MaxTries {
+				if b.Params.Logger != nil {
+					b.Params.Logger.Printf("Send of %s failed, retrying in %s: %s\n", res.Hash, wait, err)
+				}
+				time.Sleep(wait)
+				wait *= 2
+				continue
+			}
+		case *RejectedError:
+			releaseTx(res.Tx, b.Params)
+		}
+		res.Status = TxFailed
+		res.Err = sendErr
+		return
+	}
+}
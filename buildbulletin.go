@@ -34,10 +34,17 @@ func (bltnB *BulletinBuilder) SatNeeded() int64 {
 	numouts, _ := bltnB.Bulletin.NumOuts()
 	msgcost := int64(numouts) * bltnB.BurnAmnt
 
-	totalcost := msgcost + bltnB.Params.Fee
+	totalcost := msgcost + bltnB.fee()
 	return totalcost
 }
 
+// fee covers the data storing txouts of the bulletin along with change
+func (bltnB *BulletinBuilder) fee() int64 {
+	txouts, _ := bltnB.Bulletin.TxOuts(bltnB.BurnAmnt, bltnB.Params.NetParams)
+	txouts = append(txouts, p2pkhOuts(1)...)
+	return bltnB.Params.fee(estimateSize(p2pkhIns(1), txouts))
+}
+
 func (bltnB *BulletinBuilder) Build() (*btcwire.MsgTx, error) {
 	utxo, err := selectUnspent(bltnB.SatNeeded(), bltnB.Params)
 	if err != nil {
@@ -47,7 +54,7 @@ func (bltnB *BulletinBuilder) Build() (*btcwire.MsgTx, error) {
 	// Add data storing txouts.
 	txouts, err := bltnB.Bulletin.TxOuts(bltnB.BurnAmnt, bltnB.Params.NetParams)
 	if err != nil {
-		return nil, err
+		return nil, releaseErr(err, bltnB.Params, utxo)
 	}
 	msgtx.TxOut = txouts
 
@@ -59,18 +66,20 @@ func (bltnB *BulletinBuilder) Build() (*btcwire.MsgTx, error) {
 	if changeAmnt > bltnB.Params.DustAmnt {
 		changeOut, err := makeChange(changeAmnt, bltnB.Params)
 		if err != nil {
-			return nil, err
+			return nil, releaseErr(err, bltnB.Params, utxo)
 		}
 		msgtx.AddTxOut(changeOut)
 	}
 
 	// Sign the Bulletin
-	privkey := utxo.Wif.PrivKey
-	scriptSig, err := btcscript.SignatureScript(msgtx, 0, utxo.TxOut.PkScript, btcscript.SigHashAll, privkey, true)
+	scriptSig, err := bltnB.Params.signer().Sign(msgtx, 0, utxo.TxOut.PkScript, btcscript.SigHashAll)
 	if err != nil {
-		return nil, err
+		return nil, releaseErr(err, bltnB.Params, utxo)
 	}
 	txin.SignatureScript = scriptSig
+	if err := bltnB.Params.verify(msgtx, utxo); err != nil {
+		return nil, releaseErr(err, bltnB.Params, utxo)
+	}
 	return msgtx, nil
 }
 
@@ -83,8 +92,8 @@ func (b *BulletinBuilder) Log(msg string) {
 }
 
 func (bltnB *BulletinBuilder) Summarize() string {
-	s := "==== Bulletin ====\nSatNeeded:\t%d\nTxIns:\t1\nTxOuts:\t%d\nLenData:\t%d\n"
+	s := "==== Bulletin ====\nSatNeeded:\t%d\nTxIns:\t1\nTxOuts:\t%d\nLenData:\t%d\nFeeRate:\t%d\n"
 	numouts, _ := bltnB.Bulletin.NumOuts()
 	rawB, _ := bltnB.Bulletin.Bytes()
-	return fmt.Sprintf(s, bltnB.SatNeeded(), numouts, len(rawB))
+	return fmt.Sprintf(s, bltnB.SatNeeded(), numouts, len(rawB), bltnB.Params.feeRate())
 }
@@ -24,10 +24,16 @@ func NewDustBuilder(params BuilderParams, numOuts int64) *DustBuilder {
 }
 
 func (builder *DustBuilder) SatNeeded() int64 {
-	sum := builder.NumOuts*builder.Params.DustAmnt + builder.Params.Fee
+	sum := builder.NumOuts*builder.Params.DustAmnt + builder.fee()
 	return sum
 }
 
+// fee covers a single input and NumOuts pay to pubkey hash outputs
+func (builder *DustBuilder) fee() int64 {
+	size := estimateSize(p2pkhIns(1), p2pkhOuts(builder.NumOuts))
+	return builder.Params.fee(size)
+}
+
 // A transaction that contains only dust ouputs and obeys the TxBuilder interface
 func (builder *DustBuilder) Build() (*btcwire.MsgTx, error) {
 
@@ -42,7 +48,6 @@ func (builder *DustBuilder) Build() (*btcwire.MsgTx, error) {
 
 	oldTxOut := inparams.TxOut
 	outpoint := inparams.OutPoint
-	wifkey := inparams.Wif
 
 	msgtx := btcwire.NewMsgTx()
 
@@ -54,20 +59,22 @@ func (builder *DustBuilder) Build() (*btcwire.MsgTx, error) {
 		addr := dataAddr(dumb, builder.Params.NetParams)
 		addrScript, err := btcscript.PayToAddrScript(addr)
 		if err != nil {
-			return nil, err
+			return nil, releaseErr(err, builder.Params, inparams)
 		}
 		txOut := btcwire.NewTxOut(builder.Params.DustAmnt, addrScript)
 		msgtx.AddTxOut(txOut)
 	}
 
 	// sign as usual
-	privkey := wifkey.PrivKey
-	sig, err := btcscript.SignatureScript(msgtx, 0, oldTxOut.PkScript, btcscript.SigHashAll, privkey, true)
+	sig, err := builder.Params.signer().Sign(msgtx, 0, oldTxOut.PkScript, btcscript.SigHashAll)
 	if err != nil {
-		return nil, err
+		return nil, releaseErr(err, builder.Params, inparams)
 	}
 	txin.SignatureScript = sig
 
+	if err := builder.Params.verify(msgtx, inparams); err != nil {
+		return nil, releaseErr(err, builder.Params, inparams)
+	}
 	return msgtx, nil
 }
 
@@ -76,6 +83,6 @@ func (b *DustBuilder) Log(s string) {
 }
 
 func (b *DustBuilder) Summarize() string {
-	s := "==== Dust Transaction ====\nSatNeeded:\t%d\nTxIns:\t1\nTxOuts:\t%d\n"
-	return fmt.Sprintf(s, b.SatNeeded(), b.NumOuts)
+	s := "==== Dust Transaction ====\nSatNeeded:\t%d\nTxIns:\t1\nTxOuts:\t%d\nFeeRate:\t%d\n"
+	return fmt.Sprintf(s, b.SatNeeded(), b.NumOuts, b.Params.feeRate())
 }
@@ -2,23 +2,29 @@ package btcbuilder
 
 import (
 	"log"
-	"os"
 
-	"github.com/conformal/btcjson"
 	"github.com/conformal/btcnet"
 	"github.com/conformal/btcrpcclient"
+	"github.com/conformal/btcutil"
 	"github.com/conformal/btcwire"
 )
 
 type BuilderParams struct {
-	Fee        int64
-	DustAmnt   int64
-	InTarget   int64 // The target input a transaction must be created with
-	Logger     *log.Logger
-	Client     *btcrpcclient.Client
-	NetParams  *btcnet.Params
-	PendingSet map[string]struct{}
-	List       []btcjson.ListUnspentResult
+	FeeRate      int64        // Satoshis paid per byte of the signed tx
+	FeeEstimator FeeEstimator // Overrides FeeRate when set
+	ConfTarget   int          // The number of blocks the FeeEstimator aims for
+	DustAmnt     int64
+	InTarget     int64 // The target input a transaction must be created with
+	Logger       *log.Logger
+	Client       *btcrpcclient.Client
+	NetParams    *btcnet.Params
+	Source       UtxoSource // Where unspents and their keys come from
+	Signer       Signer
+	CoinSelector CoinSelector     // How composed inputs are chosen
+	SkipVerify   bool             // Built txs go through the script engine unless set
+	Policy       *Policy          // When set Send refuses txs that break it
+	Reservations ReservationStore // Unspents claimed by builders, nil reserves nothing
+	Cache        *UtxoCache       // Snapshot of Source, nil lists on every pick
 }
 
 type TxBuilder interface {
@@ -31,48 +37,66 @@ type TxBuilder interface {
 	Summarize() string
 }
 
-func SetParams(net btcwire.BitcoinNet, params BuilderParams) BuilderParams {
-	if params.Logger == nil {
-		params.Logger = log.New(os.Stdout, "", log.Ltime|log.Llongfile)
+// source returns the UtxoSource builders draw from. A Cache is used before
+// the Source, and params put together without either fall back on the wallet
+// behind the rpc client.
+func (params BuilderParams) source() UtxoSource {
+	if params.Cache != nil {
+		return params.Cache
 	}
-	if params.Client == nil {
-		client, currnet := ConfigureApp()
-		params.Client = client
-		params.NetParams = &currnet
-		params.PendingSet = make(map[string]struct{})
-		params.List = make([]btcjson.ListUnspentResult, 0)
+	if params.Source == nil {
+		return NewRPCSource(params.Client, params.NetParams)
 	}
-
-	return params
+	return params.Source
 }
 
-// TODO combine entry points into library into one global configuration function
-func CreateParams() BuilderParams {
-	var logger *log.Logger = log.New(os.Stdout, "", log.Ltime|log.Llongfile)
-	client, params := ConfigureApp()
-
-	bp := BuilderParams{
-		Fee:        20000,
-		DustAmnt:   546,
-		InTarget:   100000,
-		Logger:     logger,
-		Client:     client,
-		NetParams:  &params,
-		PendingSet: make(map[string]struct{}),
-		List:       make([]btcjson.ListUnspentResult, 0),
+// signer returns the Signer builders sign with. Without one the keys are
+// fetched from the params' UtxoSource.
+func (params BuilderParams) signer() Signer {
+	if params.Signer == nil {
+		return NewSourceSigner(params.source())
 	}
-	return bp
+	return params.Signer
 }
 
-func Send(builder TxBuilder, params BuilderParams) *btcwire.ShaHash {
+// Send builds the tx and broadcasts it through the params' rpc client. Failures
+// come back as a *BuildError, *PolicyError, *RejectedError, *AlreadyInChainError
+// or *TransportError. When the node already has the tx its hash is returned along
+// with the error.
+func Send(builder TxBuilder, params BuilderParams) (*btcwire.ShaHash, error) {
 	msg, err := builder.Build()
 	if err != nil {
-		log.Fatal(err)
+		return nil, &BuildError{Err: err}
+	}
+	if params.Policy != nil {
+		violations := NewPolicyChecker(*params.Policy).Check(msg)
+		if len(violations) > 0 {
+			releaseTx(msg, params)
+			return nil, &PolicyError{Policy: params.Policy.Name, Violations: violations}
+		}
 	}
-	println(ToHex(msg))
+	builder.Log(ToHex(msg))
 	resp, err := params.Client.SendRawTransaction(msg, false)
 	if err != nil {
-		log.Fatal(err)
+		hash := btcutil.NewTx(msg).Sha()
+		sendErr := sendError(err, hash)
+		switch sendErr.(type) {
+		case *AlreadyInChainError:
+			params.applyTx(msg)
+			return hash, sendErr
+		case *RejectedError:
+			// The tx is dead so its inputs are free for the next one
+			releaseTx(msg, params)
+		}
+		return nil, sendErr
+	}
+	params.applyTx(msg)
+	return resp, nil
+}
+
+// applyTx brings the params' Cache up to date with a tx the node accepted.
+func (params BuilderParams) applyTx(msgtx *btcwire.MsgTx) {
+	if params.Cache != nil {
+		params.Cache.Apply(msgtx)
 	}
-	return resp
 }
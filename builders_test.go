@@ -0,0 +1,1218 @@
+package btcbuilder
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/NSkelsey/btcbuilder/mockrpc"
+	"github.com/NSkelsey/protocol/ahimsa"
+	"github.com/conformal/btcnet"
+	"github.com/conformal/btcscript"
+	"github.com/conformal/btcutil"
+	"github.com/conformal/btcwire"
+)
+
+// mockParams connects a fresh set of BuilderParams to a mock node. The caller
+// must Close the server.
+func mockParams(t *testing.T) (BuilderParams, *mockrpc.Server) {
+	net := btcnet.TestNet3Params
+	srv := mockrpc.NewServer(&net)
+	params, err := NewParams(
+		WithNodeConfig(&NodeConfig{ConnCfg: srv.ConnConfig(), NetParams: &net}),
+		WithFeeRate(10),
+		WithLogger(log.New(ioutil.Discard, "", 0)),
+		WithReservations(NewMemReservations(0)),
+		WithoutCache(),
+	)
+	if err != nil {
+		srv.Close()
+		t.Fatal(err)
+	}
+	return params, srv
+}
+
+// memParams builds offline params that fund from a fresh MemSource.
+func memParams(t *testing.T) (BuilderParams, *MemSource) {
+	net := btcnet.TestNet3Params
+	src := NewMemSource(&net)
+	params, err := NewParams(
+		Offline(),
+		WithNet(&net),
+		WithSource(src),
+		WithFeeRate(10),
+		WithLogger(log.New(ioutil.Discard, "", 0)),
+		WithReservations(NewMemReservations(0)),
+		WithoutCache(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return params, src
+}
+
+var builderTests = []struct {
+	name    string
+	exact   bool // The builder needs an unspent of exactly SatNeeded
+	numOuts int  // The number of txouts expected or -1 if it varies
+	create  func(BuilderParams) TxBuilder
+}{
+	{"dust", true, 3, func(p BuilderParams) TxBuilder {
+		return NewDustBuilder(p, 3)
+	}},
+	{"pubkeyhash", true, 4, func(p BuilderParams) TxBuilder {
+		return NewPayToPubKeyHash(p, 4)
+	}},
+	{"nulldata", true, 1, func(p BuilderParams) TxBuilder {
+		return NewNullData(p, []byte("a short message"), false)
+	}},
+	{"nulldata with change", true, 2, func(p BuilderParams) TxBuilder {
+		return NewNullData(p, []byte("a short message"), true)
+	}},
+	{"multisig", true, 1, func(p BuilderParams) TxBuilder {
+		data := bytes.Repeat([]byte{0x42}, 130)
+		return NewMultiSigBuilder(p, 1, CreateList(data, newWifKeyPair(p.NetParams)))
+	}},
+	{"sendtoaddr", false, 2, func(p BuilderParams) TxBuilder {
+		addr, _ := wifToAddr(newWifKeyPair(p.NetParams), p.NetParams)
+		return NewToAddrBuilder(p, addr.EncodeAddress())
+	}},
+	{"sighashsingle", false, 2, func(p BuilderParams) TxBuilder {
+		return NewSigHashSingleBuilder(p)
+	}},
+	{"bulletin", false, -1, func(p BuilderParams) TxBuilder {
+		bltn := ahimsa.Bulletin{Topic: "testing", Message: "Hello from the mock node"}
+		return NewBulletinBuilder(p, p.DustAmnt, bltn)
+	}},
+	{"fanout", false, 4, func(p BuilderParams) TxBuilder {
+		dust := NewDustBuilder(p, 2)
+		return NewFanOutBuilder(p, []TxBuilder{dust}, 3)
+	}},
+}
+
+func TestBuilders(t *testing.T) {
+	for _, test := range builderTests {
+		params, srv := mockParams(t)
+
+		builder := test.create(params)
+		funding := int64(100000000)
+		if test.exact {
+			funding = builder.SatNeeded()
+		}
+		if _, err := srv.Fund(funding); err != nil {
+			t.Fatalf("%s: could not fund wallet: %s", test.name, err)
+		}
+
+		msgtx, err := builder.Build()
+		if err != nil {
+			t.Errorf("%s: build failed: %s", test.name, err)
+			srv.Close()
+			continue
+		}
+		if test.numOuts >= 0 && len(msgtx.TxOut) != test.numOuts {
+			t.Errorf("%s: expected %d txouts got %d", test.name, test.numOuts, len(msgtx.TxOut))
+		}
+		for _, v := range NewPolicyChecker(StandardPolicy).Check(msgtx) {
+			t.Errorf("%s: tx is not standard: %s", test.name, v)
+		}
+
+		// The mock node runs every input through the script engine
+		if _, err := params.Client.SendRawTransaction(msgtx, false); err != nil {
+			t.Errorf("%s: node rejected tx: %s", test.name, err)
+		}
+		srv.Close()
+	}
+}
+
+func TestMemSource(t *testing.T) {
+	net := btcnet.TestNet3Params
+	src := NewMemSource(&net)
+	params, err := NewParams(
+		Offline(),
+		WithNet(&net),
+		WithSource(src),
+		WithFeeRate(10),
+		WithLogger(log.New(ioutil.Discard, "", 0)),
+		WithReservations(NewMemReservations(0)),
+		WithoutCache(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	builder := NewDustBuilder(params, 2)
+	utxo, err := src.Fund(builder.SatNeeded())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msgtx, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msgtx.TxIn[0].PreviousOutPoint != *utxo.OutPoint {
+		t.Errorf("Dust tx did not spend the funded unspent")
+	}
+	if len(msgtx.TxIn[0].SignatureScript) == 0 {
+		t.Errorf("Dust tx was not signed")
+	}
+}
+
+func TestDustWithoutExactUnspent(t *testing.T) {
+	params, srv := mockParams(t)
+	defer srv.Close()
+	builder := NewDustBuilder(params, 2)
+	if _, err := srv.Fund(builder.SatNeeded() + 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := builder.Build(); err == nil {
+		t.Fatal("Built a dust tx without an unspent of the exact value")
+	}
+	unspents, err := params.source().ListUnspent()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, utxo := range unspents {
+		if params.Reservations.Reserved(utxo.OutPoint) {
+			t.Errorf("%s did not match and is still reserved", outPointStr(utxo.OutPoint))
+		}
+	}
+}
+
+func TestNewParams(t *testing.T) {
+	net := btcnet.TestNet3Params
+	params, err := NewParams(Offline(), WithNet(&net), WithLogger(log.New(ioutil.Discard, "", 0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params.Client != nil || params.Source == nil || params.Signer == nil || params.Cache == nil {
+		t.Errorf("Offline params not filled in: %+v", params)
+	}
+
+	if _, err := NewParams(Offline()); err == nil {
+		t.Error("Offline params without a network were accepted")
+	}
+	params, err = NewParams(Offline(), WithNet(&net), WithFeeEstimator(NewStaticFeeEstimator(DefaultFeeTable), 6))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := params.FeeEstimator.(*FeeSnapshot); !ok {
+		t.Errorf("Estimator is a %T, expected it wrapped in a *FeeSnapshot", params.FeeEstimator)
+	}
+	if _, err := NewParams(Offline(), WithNet(&net), WithDust(0)); err == nil {
+		t.Error("Zero dust was accepted")
+	}
+	if _, err := NewParams(Offline(), WithNet(&net), WithFeeRate(maxFeeRate+1)); err == nil {
+		t.Error("An absurd fee rate was accepted")
+	}
+	if _, err := NewParams(Offline(), WithNet(&net), WithFeeRate(0)); err == nil {
+		t.Error("A zero fee rate without an estimator was accepted")
+	}
+
+	srv := mockrpc.NewServer(&net)
+	defer srv.Close()
+	node := &NodeConfig{ConnCfg: srv.ConnConfig(), NetParams: &net}
+	if _, err := NewParams(WithNodeConfig(node), WithNet(&btcnet.MainNetParams)); err == nil {
+		t.Error("Params for mainnet were built against a testnet node")
+	}
+
+	// A conf for the wrong network gives way to what the node says
+	node.NetParams = &btcnet.MainNetParams
+	params, err = NewParams(WithNodeConfig(node), WithLogger(log.New(ioutil.Discard, "", 0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params.NetParams.Name != net.Name {
+		t.Errorf("Node on %s detected as %s", net.Name, params.NetParams.Name)
+	}
+	// and without a Logger to warn through NewParams still gets to Validate
+	if _, err := NewParams(WithNodeConfig(node), WithLogger(nil)); err == nil {
+		t.Error("Params without a Logger were accepted")
+	}
+}
+
+func TestVerifyNamesInput(t *testing.T) {
+	net := btcnet.TestNet3Params
+	src := NewMemSource(&net)
+	params := BuilderParams{
+		FeeRate:      10,
+		DustAmnt:     546,
+		Logger:       log.New(ioutil.Discard, "", 0),
+		NetParams:    &net,
+		Source:       src,
+		Signer:       src,
+		Reservations: NewMemReservations(0),
+	}
+
+	builder := NewDustBuilder(params, 1)
+	utxo, err := src.Fund(builder.SatNeeded())
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgtx, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Paying more after signing invalidates the SigHashAll signature
+	msgtx.TxOut[0].Value++
+	err = VerifyTx(msgtx, [][]byte{utxo.TxOut.PkScript})
+	verr, ok := err.(*VerifyError)
+	if !ok {
+		t.Fatalf("Expected a *VerifyError, got: %v", err)
+	}
+	if verr.Index != 0 {
+		t.Errorf("Expected input 0 to fail, got %d", verr.Index)
+	}
+}
+
+func TestPolicyViolations(t *testing.T) {
+	msgtx := btcwire.NewMsgTx()
+	msgtx.AddTxIn(btcwire.NewTxIn(btcwire.NewOutPoint(&btcwire.ShaHash{}, 0), []byte{}))
+
+	data := bytes.Repeat([]byte{0x01}, 60)
+	script := btcscript.NewScriptBuilder().AddOp(btcscript.OP_RETURN).AddData(data).Script()
+	msgtx.AddTxOut(btcwire.NewTxOut(0, script))
+	addr := dataAddr(bytes.Repeat([]byte{66}, 20), &btcnet.TestNet3Params)
+	pkScript, _ := btcscript.PayToAddrScript(addr)
+	msgtx.AddTxOut(btcwire.NewTxOut(100, pkScript))
+
+	tests := []struct {
+		policy Policy
+		rules  []string
+	}{
+		{StandardPolicy, []string{"datacarrier-size", "dust"}},
+		{DataCarrier80Policy, []string{"dust"}},
+	}
+	for _, test := range tests {
+		violations := NewPolicyChecker(test.policy).Check(msgtx)
+		if len(violations) != len(test.rules) {
+			t.Errorf("%s: expected %d violations, got %v", test.policy.Name, len(test.rules), violations)
+			continue
+		}
+		for i, v := range violations {
+			if v.Rule != test.rules[i] {
+				t.Errorf("%s: expected %s, got %s", test.policy.Name, test.rules[i], v)
+			}
+		}
+	}
+}
+
+// builtTx hands Send a tx that was built ahead of time.
+type builtTx struct {
+	TxBuilder
+	msgtx *btcwire.MsgTx
+}
+
+func (b *builtTx) Build() (*btcwire.MsgTx, error) { return b.msgtx, nil }
+
+func TestSendErrors(t *testing.T) {
+	params, srv := mockParams(t)
+	defer srv.Close()
+
+	reserved := func(msgtx *btcwire.MsgTx) bool {
+		for _, txin := range msgtx.TxIn {
+			if params.Reservations.Reserved(&txin.PreviousOutPoint) {
+				return true
+			}
+		}
+		return false
+	}
+
+	// Changing a txout after signing breaks the signature
+	dust := NewDustBuilder(params, 2)
+	if _, err := srv.Fund(dust.SatNeeded()); err != nil {
+		t.Fatal(err)
+	}
+	msgtx, err := dust.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgtx.TxOut[0].Value++
+	_, err = Send(&builtTx{dust, msgtx}, params)
+	if rejErr, ok := err.(*RejectedError); !ok || rejErr.Code != mockrpc.ErrVerifyRejected {
+		t.Errorf("Tampered tx gave %v, expected a RejectedError with code %d", err, mockrpc.ErrVerifyRejected)
+	}
+	if reserved(msgtx) {
+		t.Errorf("Inputs of the rejected tx are still reserved")
+	}
+
+	// Codes with no meaning to the library are refusals too
+	msgtx.TxOut[0].Value--
+	srv.ErrorNext("sendrawtransaction", mockrpc.ErrInvalidParameter, "Invalid parameter")
+	_, err = Send(&builtTx{dust, msgtx}, params)
+	if rejErr, ok := err.(*RejectedError); !ok || rejErr.Code != mockrpc.ErrInvalidParameter {
+		t.Errorf("Unknown error code gave %v, expected a RejectedError with code %d", err, mockrpc.ErrInvalidParameter)
+	}
+
+	sent := &builtTx{dust, msgtx}
+	hash, err := Send(sent, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resent, err := Send(sent, params)
+	if inChain, ok := err.(*AlreadyInChainError); !ok || !inChain.InMempool {
+		t.Errorf("Resending from the mempool gave %v, expected an AlreadyInChainError", err)
+	}
+	if resent == nil || !resent.IsEqual(hash) {
+		t.Errorf("Resending returned %v instead of %s", resent, hash)
+	}
+	srv.Mine()
+	_, err = Send(sent, params)
+	if inChain, ok := err.(*AlreadyInChainError); !ok || inChain.InMempool {
+		t.Errorf("Resending a mined tx gave %v, expected an AlreadyInChainError", err)
+	}
+
+	// The builder allows more data than the policy Send enforces
+	ndB := NewNullData(params, bytes.Repeat([]byte{0x01}, 60), false)
+	ndB.MaxPayload = 80
+	if _, err := srv.Fund(ndB.SatNeeded()); err != nil {
+		t.Fatal(err)
+	}
+	strict := params
+	strict.Policy = &StandardPolicy
+	_, err = Send(ndB, strict)
+	if policyErr, ok := err.(*PolicyError); !ok || policyErr.Policy != StandardPolicy.Name {
+		t.Errorf("Oversized data gave %v, expected a PolicyError", err)
+	}
+	unspents, err := params.source().ListUnspent()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, utxo := range unspents {
+		if params.Reservations.Reserved(utxo.OutPoint) {
+			t.Errorf("%s is still reserved after Send refused its tx", outPointStr(utxo.OutPoint))
+		}
+	}
+}
+
+func TestWalletSigner(t *testing.T) {
+	params, srv := mockParams(t)
+	defer srv.Close()
+	params.Signer = NewWalletSigner(params.Client)
+
+	builders := []TxBuilder{
+		NewDustBuilder(params, 3),
+		NewSigHashSingleBuilder(params),
+	}
+	for _, builder := range builders {
+		if _, err := srv.Fund(builder.SatNeeded()); err != nil {
+			t.Fatal(err)
+		}
+		msgtx, err := builder.Build()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := params.Client.SendRawTransaction(msgtx, false); err != nil {
+			t.Errorf("Node rejected a tx the wallet signed: %s", err)
+		}
+	}
+
+	// The wallet has no key for someone else's address
+	msgtx := btcwire.NewMsgTx()
+	msgtx.AddTxIn(btcwire.NewTxIn(btcwire.NewOutPoint(&btcwire.ShaHash{}, 0), nil))
+	addr, _ := wifToAddr(newWifKeyPair(params.NetParams), params.NetParams)
+	pkScript, _ := btcscript.PayToAddrScript(addr)
+	if _, err := params.Signer.Sign(msgtx, 0, pkScript, btcscript.SigHashAll); err == nil {
+		t.Errorf("Wallet signed for a key it does not hold")
+	}
+}
+
+func TestFileReservationsSurviveReopen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "btcbuilder")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "reserved.json")
+
+	held := &btcwire.OutPoint{Index: 0}
+	freed := &btcwire.OutPoint{Index: 1}
+
+	store, err := OpenFileReservations(path, DefaultReservationTTL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, op := range []*btcwire.OutPoint{held, freed} {
+		if ok, err := store.Reserve(op); !ok || err != nil {
+			t.Fatalf("Reserve %s: %v, %v", outPointStr(op), ok, err)
+		}
+	}
+	if ok, _ := store.Reserve(held); ok {
+		t.Fatal("Reserved the same outpoint twice")
+	}
+	if err := store.Release(freed); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	store, err = OpenFileReservations(path, DefaultReservationTTL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if !store.Reserved(held) {
+		t.Error("Reservation was lost on reopen")
+	}
+	if store.Reserved(freed) {
+		t.Error("Released outpoint came back on reopen")
+	}
+}
+
+// Run with -race. Every builder shares one wallet and one reservation store
+// so an unsafe pick shows up as a data race or a double spend.
+func TestConcurrentBuilders(t *testing.T) {
+	params, srv := mockParams(t)
+	defer srv.Close()
+	// NullData funds through the selector. Exact matches keep it off the
+	// unspents the DustBuilders need.
+	params.CoinSelector = &BranchAndBound{}
+
+	const numEach = 200
+	builders := make([]TxBuilder, 0, 2*numEach)
+	for i := 0; i < numEach; i++ {
+		builders = append(builders,
+			NewDustBuilder(params, 2),
+			NewNullData(params, []byte("concurrent"), false),
+		)
+	}
+	for _, builder := range builders {
+		if _, err := srv.Fund(builder.SatNeeded()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	txs := make([]*btcwire.MsgTx, len(builders))
+	errs := make([]error, len(builders))
+	for i, builder := range builders {
+		wg.Add(1)
+		go func(i int, builder TxBuilder) {
+			defer wg.Done()
+			txs[i], errs[i] = builder.Build()
+		}(i, builder)
+	}
+	wg.Wait()
+
+	spent := make(map[btcwire.OutPoint]int)
+	for i, msgtx := range txs {
+		if errs[i] != nil {
+			t.Errorf("build %d failed: %s", i, errs[i])
+			continue
+		}
+		for _, txin := range msgtx.TxIn {
+			if prev, ok := spent[txin.PreviousOutPoint]; ok {
+				t.Errorf("builds %d and %d both spend %s", prev, i, outPointStr(&txin.PreviousOutPoint))
+			}
+			spent[txin.PreviousOutPoint] = i
+		}
+	}
+}
+
+func TestUtxoCache(t *testing.T) {
+	net := btcnet.TestNet3Params
+	src := NewMemSource(&net)
+	cache := NewUtxoCache(src)
+	params := BuilderParams{
+		FeeRate:      10,
+		DustAmnt:     546,
+		InTarget:     100000,
+		Logger:       log.New(ioutil.Discard, "", 0),
+		NetParams:    &net,
+		Source:       src,
+		Signer:       src,
+		Reservations: NewMemReservations(0),
+		Cache:        cache,
+	}
+
+	builder := NewPayToPubKeyHash(params, 2)
+	if _, err := src.Fund(builder.SatNeeded()); err != nil {
+		t.Fatal(err)
+	}
+	msgtx, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Funds that arrive after the snapshot stay hidden until a Refresh
+	if _, err := src.Fund(1e6); err != nil {
+		t.Fatal(err)
+	}
+	cache.Apply(msgtx)
+	list, err := cache.ListUnspent()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 {
+		t.Fatalf("Expected the 2 txouts of the built tx got %d unspents", len(list))
+	}
+	hash := btcutil.NewTx(msgtx).Sha()
+	for _, utxo := range list {
+		if !utxo.OutPoint.Hash.IsEqual(hash) {
+			t.Errorf("Unspent %s is not from the built tx", outPointStr(utxo.OutPoint))
+		}
+	}
+
+	if err := cache.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	list, _ = cache.ListUnspent()
+	if sumInputs(list) < 1e6 {
+		t.Errorf("Refresh did not pick up the new funds")
+	}
+}
+
+func TestPackage(t *testing.T) {
+	params, srv := mockParams(t)
+	defer srv.Close()
+	params.Cache = NewUtxoCache(params.Source)
+	if _, err := srv.Fund(1e8); err != nil {
+		t.Fatal(err)
+	}
+
+	children := []TxBuilder{NewDustBuilder(params, 2), NewPayToPubKeyHash(params, 2)}
+	pkg := NewPackage(params)
+	fanout, err := pkg.Build(NewFanOutBuilder(params, children, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, child := range append(children, children...) {
+		if _, err := pkg.Build(child); err != nil {
+			t.Fatalf("Child could not spend the unbroadcast fanout: %s", err)
+		}
+	}
+
+	// Added child first the fanout must still sort to the front
+	txs := pkg.Sorted()
+	reversed := NewPackage(params)
+	for i := len(txs) - 1; i >= 0; i-- {
+		reversed.Add(txs[i])
+	}
+	sorted := reversed.Sorted()
+	if sorted[0] != fanout {
+		t.Fatal("Fanout is not ahead of the txs that spend it")
+	}
+	for i, msgtx := range sorted {
+		if _, err := params.Client.SendRawTransaction(msgtx, false); err != nil {
+			t.Errorf("Node rejected tx %d: %s", i, err)
+		}
+	}
+}
+
+// sameOutPoints reports whether a and b list the same outpoints.
+func sameOutPoints(a, b []*TxInParams) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	ops := make(map[btcwire.OutPoint]bool, len(a))
+	for _, utxo := range a {
+		ops[*utxo.OutPoint] = true
+	}
+	for _, utxo := range b {
+		if !ops[*utxo.OutPoint] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestPackageRollback(t *testing.T) {
+	params, srv := mockParams(t)
+	defer srv.Close()
+	params.Cache = NewUtxoCache(params.Source)
+	if _, err := srv.Fund(1e8); err != nil {
+		t.Fatal(err)
+	}
+	before, err := params.Cache.ListUnspent()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	child := NewDustBuilder(params, 2)
+	pkg := NewPackage(params)
+	if _, err := pkg.Build(NewFanOutBuilder(params, []TxBuilder{child}, 2)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pkg.Build(child); err != nil {
+		t.Fatal(err)
+	}
+	if err := pkg.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	after, _ := params.Cache.ListUnspent()
+	if !sameOutPoints(before, after) {
+		t.Errorf("Rollback left %d unspents, expected the %d funded", len(after), len(before))
+	}
+	for _, utxo := range after {
+		if params.Reservations.Reserved(utxo.OutPoint) {
+			t.Errorf("%s is still reserved after the rollback", outPointStr(utxo.OutPoint))
+		}
+	}
+
+	// A builder that fails partway through a Send takes the package down
+	b := NewBroadcaster(params)
+	fanout := NewFanOutBuilder(params, []TxBuilder{child}, 1)
+	if _, err := b.Send(fanout, child, NewDustBuilder(params, 7)); err == nil {
+		t.Fatal("Send built a dust tx that had no unspent to fund it")
+	}
+	after, _ = params.Cache.ListUnspent()
+	if !sameOutPoints(before, after) {
+		t.Errorf("Failed Send left %d unspents, expected the %d funded", len(after), len(before))
+	}
+}
+
+func TestBroadcaster(t *testing.T) {
+	params, srv := mockParams(t)
+	defer srv.Close()
+	params.Cache = NewUtxoCache(params.Source)
+	if _, err := srv.Fund(1e8); err != nil {
+		t.Fatal(err)
+	}
+
+	child := NewDustBuilder(params, 2)
+	pkg := NewPackage(params)
+	fanout, err := pkg.Build(NewFanOutBuilder(params, []TxBuilder{child}, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := pkg.Build(child); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The node already knows the fanout and drops the first couple of calls
+	if _, err := params.Client.SendRawTransaction(fanout, false); err != nil {
+		t.Fatal(err)
+	}
+	srv.FailNext("sendrawtransaction", 2)
+
+	b := NewBroadcaster(params)
+	b.Backoff = time.Millisecond
+	results := b.Broadcast(pkg)
+	if len(results) != 3 {
+		t.Fatalf("Expected 3 results got %d", len(results))
+	}
+	if results[0].Tx != fanout || results[0].Status != TxKnown {
+		t.Errorf("Fanout should go first and be known, got %s", results[0].Status)
+	}
+	if results[0].Tries != 3 {
+		t.Errorf("Expected 2 retries before the fanout got through, made %d tries", results[0].Tries)
+	}
+	for _, res := range results[1:] {
+		if res.Status != TxSent {
+			t.Errorf("Child %s was %s: %v", res.Hash, res.Status, res.Err)
+		}
+	}
+}
+
+func TestBroadcasterSkipsChildren(t *testing.T) {
+	params, srv := mockParams(t)
+	defer srv.Close()
+	params.Cache = NewUtxoCache(params.Source)
+	if _, err := srv.Fund(1e8); err != nil {
+		t.Fatal(err)
+	}
+
+	before, err := params.Cache.ListUnspent()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	child := NewDustBuilder(params, 2)
+	pkg := NewPackage(params)
+	fanout, err := pkg.Build(NewFanOutBuilder(params, []TxBuilder{child}, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pkg.Build(child); err != nil {
+		t.Fatal(err)
+	}
+	// The node stays down for longer than the broadcaster will wait
+	b := NewBroadcaster(params)
+	b.Backoff = time.Millisecond
+	srv.FailNext("sendrawtransaction", b.MaxTries)
+
+	results := b.Broadcast(pkg)
+	if results[0].Tx != fanout || results[0].Status != TxFailed {
+		t.Errorf("Expected the fanout to fail got %s", results[0].Status)
+	}
+	if _, ok := results[0].Err.(*TransportError); !ok {
+		t.Errorf("Expected a *TransportError got %T", results[0].Err)
+	}
+	if results[1].Status != TxSkipped {
+		t.Errorf("Expected the child to be skipped got %s", results[1].Status)
+	}
+	if len(srv.Mempool()) != 0 {
+		t.Errorf("Nothing should have reached the mempool")
+	}
+
+	// Neither tx went out so the cache is back to the funding unspent
+	after, err := params.Cache.ListUnspent()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameOutPoints(before, after) {
+		t.Errorf("Cache holds %d unspents after the failed broadcast, expected the %d it started with", len(after), len(before))
+	}
+}
+
+func TestBroadcasterRejects(t *testing.T) {
+	params, srv := mockParams(t)
+	defer srv.Close()
+	params.Cache = NewUtxoCache(params.Source)
+	if _, err := srv.Fund(1e8); err != nil {
+		t.Fatal(err)
+	}
+	before, err := params.Cache.ListUnspent()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An error code the library has no name for is still a refusal
+	srv.ErrorNext("sendrawtransaction", mockrpc.ErrWallet, "Wallet is locked")
+	b := NewBroadcaster(params)
+	b.Backoff = time.Millisecond
+	results, err := b.Send(NewDustBuilder(params, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := results[0]
+	if res.Status != TxFailed || res.Tries != 1 {
+		t.Errorf("Expected one failed try got %s after %d", res.Status, res.Tries)
+	}
+	if rejErr, ok := res.Err.(*RejectedError); !ok || rejErr.Code != mockrpc.ErrWallet {
+		t.Errorf("Expected a *RejectedError with code %d got %v", mockrpc.ErrWallet, res.Err)
+	}
+	for _, txin := range res.Tx.TxIn {
+		if params.Reservations.Reserved(&txin.PreviousOutPoint) {
+			t.Errorf("%s is still reserved after the rejection", outPointStr(&txin.PreviousOutPoint))
+		}
+	}
+	after, err := params.Cache.ListUnspent()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameOutPoints(before, after) {
+		t.Errorf("Cache holds %d unspents after the rejection, expected the %d it started with", len(after), len(before))
+	}
+}
+
+func TestP2SH(t *testing.T) {
+	params, srv := mockParams(t)
+	defer srv.Close()
+	if _, err := srv.Fund(1e8); err != nil {
+		t.Fatal(err)
+	}
+
+	// A 2 of 2 escrow where each party holds their own key
+	buyer, seller := NewKeyStore(params.NetParams), NewKeyStore(params.NetParams)
+	pubkeys := make([]*btcutil.AddressPubKey, 0, 2)
+	for _, ks := range []*KeyStore{buyer, seller} {
+		wif := newWifKeyPair(params.NetParams)
+		ks.AddKey(wif)
+		pk, err := btcutil.NewAddressPubKey(wif.SerializePubKey(), params.NetParams)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pubkeys = append(pubkeys, pk)
+	}
+	escrow, err := btcscript.MultiSigScript(pubkeys, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// and a redeem script that is just pay to pubkey hash
+	single := NewKeyStore(params.NetParams)
+	singleAddr, _ := single.AddKey(newWifKeyPair(params.NetParams))
+	pkh, _ := btcscript.PayToAddrScript(singleAddr)
+
+	msgtx, outs, err := NewP2SHBuilder(params, 100000, escrow, pkh).BuildP2SH()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range NewPolicyChecker(StandardPolicy).Check(msgtx) {
+		t.Errorf("P2SH tx is not standard: %s", v)
+	}
+	if _, err := params.Client.SendRawTransaction(msgtx, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// One party alone cannot release the escrow
+	dest, _ := newAddr(params)
+	if _, err := NewP2SHSpender(params, dest, outs[:1], buyer).Build(); err == nil {
+		t.Error("Spent a 2 of 2 escrow with one signature")
+	}
+	if params.Reservations.Reserved(outs[0].OutPoint) {
+		t.Error("The escrow is still reserved after the failed spend")
+	}
+
+	spender := NewP2SHSpender(params, dest, outs, buyer, seller, single)
+	spend, err := spender.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := params.Client.SendRawTransaction(spend, false); err != nil {
+		t.Errorf("Node rejected the P2SH spend: %s", err)
+	}
+
+	// The outs are claimed by the first spend
+	if _, err := NewP2SHSpender(params, dest, outs, buyer, seller, single).Build(); err == nil {
+		t.Error("Built a second spend of reserved outs")
+	}
+}
+
+func TestMultiSigSpender(t *testing.T) {
+	params, srv := mockParams(t)
+	defer srv.Close()
+
+	ks := NewKeyStore(params.NetParams)
+	wif := newWifKeyPair(params.NetParams)
+	ks.AddKey(wif)
+	data := bytes.Repeat([]byte{0x42}, 130)
+	builder := NewMultiSigBuilder(params, 1, CreateList(data, wif))
+	if _, err := srv.Fund(builder.SatNeeded()); err != nil {
+		t.Fatal(err)
+	}
+	msgtx, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := params.Client.SendRawTransaction(msgtx, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// The multisig txouts sit in a wallet next to an ordinary unspent that
+	// the spender must leave alone
+	src := NewMemSource(params.NetParams)
+	if _, err := src.Fund(1e6); err != nil {
+		t.Fatal(err)
+	}
+	hash := btcutil.NewTx(msgtx).Sha()
+	for i, txout := range msgtx.TxOut {
+		src.AddUnspent(btcwire.NewOutPoint(hash, uint32(i)), txout)
+	}
+	params.Source = src
+
+	dest, _ := wifToAddr(newWifKeyPair(params.NetParams), params.NetParams)
+	sweep, err := NewMultiSigSpender(params, dest, ks).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sweep.TxIn) != len(msgtx.TxOut) {
+		t.Errorf("Expected to sweep %d multisig txouts got %d", len(msgtx.TxOut), len(sweep.TxIn))
+	}
+	if _, err := params.Client.SendRawTransaction(sweep, false); err != nil {
+		t.Errorf("Node rejected the sweep: %s", err)
+	}
+}
+
+func TestMultiSigSpenderOuts(t *testing.T) {
+	params, srv := mockParams(t)
+	defer srv.Close()
+	wif := newWifKeyPair(params.NetParams)
+	pubkey, _ := btcutil.NewAddressPubKey(wif.SerializePubKey(), params.NetParams)
+	pkScript, _ := btcscript.MultiSigScript([]*btcutil.AddressPubKey{pubkey}, 1)
+	outs := make([]*TxInParams, 2)
+	for i := range outs {
+		outs[i] = &TxInParams{
+			TxOut:    btcwire.NewTxOut(1e5, pkScript),
+			OutPoint: btcwire.NewOutPoint(&btcwire.ShaHash{}, uint32(i)),
+		}
+	}
+	dest, _ := wifToAddr(newWifKeyPair(params.NetParams), params.NetParams)
+
+	// Nobody holds the key so signing fails and both outs are handed back
+	spender := NewMultiSigSpender(params, dest, NewKeyStore(params.NetParams))
+	spender.Outs = outs
+	if _, err := spender.Build(); err == nil {
+		t.Fatal("Spent multisig outs without the key")
+	}
+	for _, out := range outs {
+		if params.Reservations.Reserved(out.OutPoint) {
+			t.Errorf("%s is still reserved after the failed Build", outPointStr(out.OutPoint))
+		}
+	}
+
+	// An out someone else reserved is neither spent nor released
+	if _, err := params.Reservations.Reserve(outs[1].OutPoint); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := spender.Build(); err == nil {
+		t.Fatal("Spent an out that was already reserved")
+	}
+	if params.Reservations.Reserved(outs[0].OutPoint) {
+		t.Errorf("The free out was left reserved")
+	}
+	if !params.Reservations.Reserved(outs[1].OutPoint) {
+		t.Errorf("Build released a reservation it did not make")
+	}
+}
+
+func TestMultiSigValidate(t *testing.T) {
+	net := btcnet.TestNet3Params
+	params := BuilderParams{NetParams: &net}
+	wif := newWifKeyPair(&net)
+
+	list, err := CreateListN(bytes.Repeat([]byte{0x42}, 200), 5, 33, wif)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 7 chunks of 33 bytes fill the 4 data slots of 2 txouts
+	if len(list) != 2 {
+		t.Fatalf("Expected 2 txouts of data got %d", len(list))
+	}
+	if err := NewMultiSigBuilder(params, 1, list).Validate(); err != nil {
+		t.Errorf("1 of 5 rejected: %s", err)
+	}
+
+	if _, err := CreateListN([]byte{0x42}, maxMultiSigKeys+1, 33, wif); err == nil {
+		t.Errorf("Made a list with %d key slots", maxMultiSigKeys+1)
+	}
+
+	key := wif.SerializePubKey()
+	seventeen := make([][]byte, 17)
+	for i := range seventeen {
+		seventeen[i] = key
+	}
+	tests := []struct {
+		name   string
+		m      int64
+		list   [][][]byte
+		strict bool
+	}{
+		{"empty list", 1, [][][]byte{}, false},
+		{"m over n", 3, [][][]byte{{key, key}}, false},
+		{"m of zero", 0, [][][]byte{{key, key}}, false},
+		{"ragged entry", 1, [][][]byte{{key, key}, {key}}, false},
+		{"short key", 1, [][][]byte{{key, key[:20]}}, false},
+		{"nonstandard n", 1, [][][]byte{seventeen}, false},
+		{"data as a key", 1, list, true},
+	}
+	for _, test := range tests {
+		builder := NewMultiSigBuilder(params, test.m, test.list)
+		builder.StrictKeys = test.strict
+		if err := builder.Validate(); err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		}
+	}
+}
+
+func TestNullDataChunks(t *testing.T) {
+	params, srv := mockParams(t)
+	defer srv.Close()
+
+	doc := bytes.Repeat([]byte("a document hash plus its metadata "), 6)
+	if _, err := NewNullData(params, doc[:80], false).Build(); err == nil {
+		t.Error("Built an 80 byte payload under the standard policy")
+	}
+
+	builders, err := NewNullDataChunks(params, doc, false, 40)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, builder := range builders {
+		if _, err := srv.Fund(builder.SatNeeded()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	txs := make([]*btcwire.MsgTx, len(builders))
+	for i, builder := range builders {
+		if txs[i], err = builder.Build(); err != nil {
+			t.Fatal(err)
+		}
+		for _, v := range NewPolicyChecker(StandardPolicy).Check(txs[i]) {
+			t.Errorf("Chunk %d is not standard: %s", i, v)
+		}
+	}
+
+	// Chunks can turn up in any order
+	r := NewReassembler()
+	var payloads [][]byte
+	for i := len(txs) - 1; i >= 0; i-- {
+		done, err := r.AddTx(txs[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		payloads = append(payloads, done...)
+	}
+	if len(payloads) != 1 || !bytes.Equal(payloads[0], doc) {
+		t.Errorf("Reassembled %d payloads that do not match the document", len(payloads))
+	}
+	if r.Pending() != 0 {
+		t.Errorf("%d payloads left pending", r.Pending())
+	}
+
+	p80 := DataCarrier80Policy
+	params.Policy = &p80
+	builder := NewNullData(params, doc[:80], false)
+	if _, err := srv.Fund(builder.SatNeeded()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := builder.Build(); err != nil {
+		t.Errorf("80 byte payload under the datacarrier80 policy: %s", err)
+	}
+
+	// A policy that relays several OP_RETURN outputs fits more in one tx
+	multi := DataCarrier80Policy
+	multi.MaxDataOutputs = 3
+	params.Policy = &multi
+	builder = NewNullData(params, doc[:200], false)
+	if _, err := srv.Fund(builder.SatNeeded()); err != nil {
+		t.Fatal(err)
+	}
+	msgtx, err := builder.Build()
+	if err != nil {
+		t.Fatalf("200 byte payload over 3 outputs: %s", err)
+	}
+	dataOuts := 0
+	for _, txout := range msgtx.TxOut {
+		if isNullData(txout.PkScript) {
+			dataOuts++
+		}
+	}
+	if dataOuts != 3 || !bytes.Equal(NullData(msgtx), doc[:200]) {
+		t.Errorf("Spread 200 bytes over %d OP_RETURN txouts and read back %x", dataOuts, NullData(msgtx))
+	}
+	for _, v := range NewPolicyChecker(multi).Check(msgtx) {
+		t.Errorf("Multi output tx is not standard: %s", v)
+	}
+	if _, err := NewNullData(params, doc, false).Build(); err == nil {
+		t.Errorf("Built %d bytes into 3 outputs of 80", len(doc))
+	}
+
+	builders, err = NewNullDataChunks(params, doc, false, 80)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(builders) != 1 {
+		t.Fatalf("Chunks of %d bytes took %d txs, expected 1", len(doc), len(builders))
+	}
+	if _, err := srv.Fund(builders[0].SatNeeded()); err != nil {
+		t.Fatal(err)
+	}
+	if msgtx, err = builders[0].Build(); err != nil {
+		t.Fatal(err)
+	}
+	if done, err := NewReassembler().AddTx(msgtx); err != nil || len(done) != 1 || !bytes.Equal(done[0], doc) {
+		t.Errorf("Reassembled %d payloads from one tx of chunks: %v", len(done), err)
+	}
+
+	// Chunks can not outgrow a single push
+	if _, err := NewNullDataChunks(params, doc, false, maxPushSize+1); err == nil {
+		t.Errorf("Chunked into payloads over the %d byte push limit", maxPushSize)
+	}
+	if _, err := ChunkData(doc, maxPushSize); err != nil {
+		t.Errorf("Chunking at the push limit: %s", err)
+	}
+}
+
+func TestNullDataChange(t *testing.T) {
+	params, srv := mockParams(t)
+	defer srv.Close()
+	if _, err := srv.Fund(1e6); err != nil {
+		t.Fatal(err)
+	}
+
+	builder := NewNullData(params, []byte("timestamp"), false)
+	builder.Burn = 1000
+	msgtx, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgtx.TxOut) != 2 {
+		t.Fatalf("Expected the leftover of a large input back as change, got %d txouts", len(msgtx.TxOut))
+	}
+	if msgtx.TxOut[0].Value != 1000 {
+		t.Errorf("Burned %d not 1000", msgtx.TxOut[0].Value)
+	}
+	fee := 1e6 - sumOutputs(msgtx)
+	if want := params.fee(msgtx.SerializeSize()); fee > want+params.FeeRate*4 {
+		t.Errorf("Paid %d in fees for a tx that should cost about %d", fee, want)
+	}
+	if summary := builder.Summarize(); !strings.Contains(summary, "TxIns:\t1\nTxOuts:\t2\n") || !strings.Contains(summary, fmt.Sprintf("Fee:\t%d\n", fee)) {
+		t.Errorf("Summary does not match the tx built:\n%s", summary)
+	}
+	if _, err := params.Client.SendRawTransaction(msgtx, false); err != nil {
+		t.Errorf("Node rejected tx: %s", err)
+	}
+}
+
+func TestClassify(t *testing.T) {
+	params, src := memParams(t)
+	bltn := ahimsa.Bulletin{
+		Topic:   "classifier",
+		Message: "Bulletins spell their message out in the hashes of pay to pubkey hash txouts, which reads as text.",
+	}
+
+	tests := []struct {
+		builder TxBuilder
+		pattern Pattern
+	}{
+		{NewDustBuilder(params, 4), PatternDust},
+		{NewNullData(params, []byte("classified"), false), PatternNullData},
+		{NewSigHashSingleBuilder(params), PatternSigHashSingle},
+		{NewFanOutBuilder(params, []TxBuilder{NewDustBuilder(params, 2)}, 3), PatternFanOut},
+		{NewBulletinBuilder(params, params.DustAmnt, bltn), PatternBulletin},
+	}
+	for _, test := range tests {
+		if _, err := src.Fund(test.builder.SatNeeded()); err != nil {
+			t.Fatal(err)
+		}
+		msgtx, err := test.builder.Build()
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := Classify(msgtx)
+		if c.Pattern != test.pattern {
+			t.Errorf("Classified a %s tx as %s (%.2f)", test.pattern, c.Pattern, c.Confidence)
+		}
+		for i, class := range c.Inputs {
+			if class != btcscript.PubKeyHashTy {
+				t.Errorf("%s: input %d read as %s", test.pattern, i, class)
+			}
+		}
+	}
+
+	msgtx, err := embed(FrameData(bytes.Repeat([]byte("data"), 40)), 3, uncompressedKeySize, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := Classify(msgtx); c.Pattern != PatternMultiSigData || c.Kind != "multisig" {
+		t.Errorf("Data in multisig classified as %s, kind %s", c.Pattern, c.Kind)
+	}
+
+	// The most common output class is the kind
+	addrs := make([]btcutil.Address, 0, 3)
+	for i := 0; i < 2; i++ {
+		addr, err := src.NewAddr()
+		if err != nil {
+			t.Fatal(err)
+		}
+		addrs = append(addrs, addr)
+	}
+	p2sh, err := btcutil.NewAddressScriptHash([]byte{btcscript.OP_TRUE}, params.NetParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addrs = append(addrs, p2sh)
+	msgtx = btcwire.NewMsgTx()
+	for _, addr := range addrs {
+		pkScript, _ := btcscript.PayToAddrScript(addr)
+		msgtx.AddTxOut(btcwire.NewTxOut(1e4, pkScript))
+	}
+	// SelectKind goes by the rarest class, Classify by the most common
+	if kind := SelectKind(msgtx); kind != btcscript.ScriptHashTy.String() {
+		t.Errorf("SelectKind of a tx with one pay to script hash txout is %s", kind)
+	}
+	if c := Classify(msgtx); c.Kind != btcscript.PubKeyHashTy.String() {
+		t.Errorf("Kind of a mostly pay to pubkey hash tx is %s", c.Kind)
+	}
+}
@@ -26,25 +26,38 @@ func NewFanOutBuilder(params BuilderParams, builders []TxBuilder, copies int) *F
 }
 
 func (fanB *FanOutBuilder) SatNeeded() int64 {
+	// Good Citizens pay the toll
+	return fanB.outVal() + fanB.fee(p2pkhIns(1))
+}
+
+// outVal is the value sent to the txouts other builders will use
+func (fanB *FanOutBuilder) outVal() int64 {
 	sum := int64(0)
 	for _, builder := range fanB.Builders {
 		sum += builder.SatNeeded() * fanB.Copies
 	}
-	// Good Citizens pay the toll
-	sum += fanB.Params.Fee
 	return sum
 }
 
+// fee covers the inputs spending prevScripts, a txout for every copy and change
+func (fanB *FanOutBuilder) fee(prevScripts [][]byte) int64 {
+	numOuts := int64(len(fanB.Builders))*fanB.Copies + 1
+	size := estimateSize(prevScripts, p2pkhOuts(numOuts))
+	return fanB.Params.fee(size)
+}
+
 func (fanB *FanOutBuilder) Build() (*btcwire.MsgTx, error) {
-	totalSpent := fanB.SatNeeded()
+	outVal := fanB.outVal()
 
-	// Compose a set of Txins with enough to fund this transactions needs
-	inParamSet, totalIn, err := composeUnspents(
-		totalSpent,
-		fanB.Params)
+	// Compose a set of Txins with enough to fund this transactions needs.
+	need := func(prevScripts [][]byte) int64 {
+		return outVal + fanB.fee(prevScripts)
+	}
+	inParamSet, totalIn, err := composeFunded(need, fanB.Params)
 	if err != nil {
 		return nil, err
 	}
+	totalSpent := need(inScripts(inParamSet))
 
 	msgtx := btcwire.NewMsgTx()
 	// funding inputs speced out with blank
@@ -57,9 +70,9 @@ func (fanB *FanOutBuilder) Build() (*btcwire.MsgTx, error) {
 		builder := fanB.Builders[i]
 		amnt := builder.SatNeeded()
 		for j := int64(0); j < fanB.Copies; j++ {
-			addr, err := newAddr(fanB.Params.Client)
+			addr, err := newAddr(fanB.Params)
 			if err != nil {
-				return nil, err
+				return nil, releaseErr(err, fanB.Params, inParamSet...)
 			}
 			script, _ := btcscript.PayToAddrScript(addr)
 			txout := btcwire.NewTxOut(amnt, script)
@@ -67,9 +80,9 @@ func (fanB *FanOutBuilder) Build() (*btcwire.MsgTx, error) {
 		}
 	}
 
-	changeAddr, err := newAddr(fanB.Params.Client)
+	changeAddr, err := newAddr(fanB.Params)
 	if err != nil {
-		return nil, err
+		return nil, releaseErr(err, fanB.Params, inParamSet...)
 	}
 	// change to solve unevenness
 	change, ok := changeOutput(totalIn-totalSpent, fanB.Params.DustAmnt, changeAddr)
@@ -78,20 +91,16 @@ func (fanB *FanOutBuilder) Build() (*btcwire.MsgTx, error) {
 	}
 
 	// sign msgtx for each input
-	for i, inpParam := range inParamSet {
-		privkey := inpParam.Wif.PrivKey
-		subscript := inpParam.TxOut.PkScript
-		sigflag := btcscript.SigHashAll
-		scriptSig, err := btcscript.SignatureScript(msgtx, i, subscript,
-			sigflag, privkey, true)
-		if err != nil {
-			return nil, err
-		}
-		msgtx.TxIn[i].SignatureScript = scriptSig
+	err = signInputs(msgtx, inParamSet, btcscript.SigHashAll, fanB.Params)
+	if err != nil {
+		return nil, releaseErr(err, fanB.Params, inParamSet...)
 	}
 	fanB.Log(fmt.Sprintf("InVal: %d\n", sumInputs(inParamSet)))
 	fanB.Log(fmt.Sprintf("OutVal: %d\n", sumOutputs(msgtx)))
 
+	if err := fanB.Params.verify(msgtx, inParamSet...); err != nil {
+		return nil, releaseErr(err, fanB.Params, inParamSet...)
+	}
 	return msgtx, nil
 }
 
@@ -100,8 +109,8 @@ func (fanB *FanOutBuilder) Log(msg string) {
 }
 
 func (fanB *FanOutBuilder) Summarize() string {
-	s := "==== Fanout Tx ====\nSatNeeded:\t%d\nTxIns:\t?\nTxOuts:\t%d\n"
-	s = fmt.Sprintf(s, fanB.SatNeeded(), int(fanB.Copies)*len(fanB.Builders))
+	s := "==== Fanout Tx ====\nSatNeeded:\t%d\nTxIns:\t?\nTxOuts:\t%d\nFeeRate:\t%d\n"
+	s = fmt.Sprintf(s, fanB.SatNeeded(), int(fanB.Copies)*len(fanB.Builders), fanB.Params.feeRate())
 	for _, builder := range fanB.Builders {
 		s = s + builder.Summarize()
 	}
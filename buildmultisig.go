@@ -1,34 +1,85 @@
 package btcbuilder
 
 import (
+	"errors"
 	"fmt"
-	"math"
 
+	"github.com/conformal/btcec"
 	"github.com/conformal/btcscript"
 	"github.com/conformal/btcutil"
 	"github.com/conformal/btcwire"
 )
 
+// The most keys a bare multisig script can hold. OP_CHECKMULTISIG accepts up
+// to 20 but past 16 N can not be pushed with a small int opcode and the script
+// is no longer standard.
+const maxMultiSigKeys = 16
+
+// Sizes of a serialized pubkey.
+const (
+	compressedKeySize   = 33
+	uncompressedKeySize = 65
+)
+
 // A Standard multi sig builder using the bip11 method
 // IE OP_0 m [pub keys] n OP_CHECKMULTISIG
 type MultiSigBuilder struct {
 	Params     BuilderParams
 	M          int64      // min sigs needed for every tx out
-	N          int64      // number of sigs
+	N          int64      // number of keys in every tx out
 	PubKeyList [][][]byte // the list of raw pubkeys to insert into txouts
+	// StrictKeys requires every key to be a point on the curve. Lists from
+	// CreateList carry data in their key slots and fail this.
+	StrictKeys bool
 }
 
+// NewMultiSigBuilder takes N from the first entry of pklist.
 func NewMultiSigBuilder(params BuilderParams, m int64, pklist [][][]byte) *MultiSigBuilder {
-	numSigs := 3
+	var n int64
+	if len(pklist) > 0 {
+		n = int64(len(pklist[0]))
+	}
 	msb := MultiSigBuilder{
 		Params:     params,
 		M:          m,
-		N:          int64(numSigs),
+		N:          n,
 		PubKeyList: pklist,
 	}
 	return &msb
 }
 
+// Validate checks the M of N configuration and that every entry of PubKeyList
+// holds N keys of a size a pubkey can have.
+func (msB *MultiSigBuilder) Validate() error {
+	if len(msB.PubKeyList) < 1 {
+		return errors.New("PubKeyList is empty")
+	}
+	if msB.N < 1 || msB.N > maxMultiSigKeys {
+		return fmt.Errorf("N is %d, it must be between 1 and %d", msB.N, maxMultiSigKeys)
+	}
+	if msB.M < 1 || msB.M > msB.N {
+		return fmt.Errorf("M is %d, it must be between 1 and N (%d)", msB.M, msB.N)
+	}
+	for i, pubkeys := range msB.PubKeyList {
+		if int64(len(pubkeys)) != msB.N {
+			return fmt.Errorf("Entry %d of PubKeyList has %d keys, not N (%d)", i, len(pubkeys), msB.N)
+		}
+		for j, pk := range pubkeys {
+			if len(pk) != compressedKeySize && len(pk) != uncompressedKeySize {
+				return fmt.Errorf("Key %d of entry %d is %d bytes, not %d or %d",
+					j, i, len(pk), compressedKeySize, uncompressedKeySize)
+			}
+			if !msB.StrictKeys {
+				continue
+			}
+			if _, err := btcec.ParsePubKey(pk, btcec.S256()); err != nil {
+				return fmt.Errorf("Key %d of entry %d is not a valid pubkey: %s", j, i, err)
+			}
+		}
+	}
+	return nil
+}
+
 func (msB *MultiSigBuilder) SatNeeded() int64 {
 	sum := msB.Params.InTarget
 	return sum
@@ -37,13 +88,40 @@ func (msB *MultiSigBuilder) SatNeeded() int64 {
 func (msB *MultiSigBuilder) eachOutVal() int64 {
 	numouts := int64(len(msB.PubKeyList))
 	total := msB.Params.InTarget
-	fee := msB.Params.Fee
+	fee := msB.fee()
 
 	return (total - fee) / numouts
 }
 
-// TODO This will add multisig Txouts to the unspent set be AWARE
+// fee covers a single input and a multisig txout for every entry in PubKeyList
+func (msB *MultiSigBuilder) fee() int64 {
+	txouts := make([]*btcwire.TxOut, 0, len(msB.PubKeyList))
+	for _, pubkeys := range msB.PubKeyList {
+		txouts = append(txouts, btcwire.NewTxOut(0, msB.pkScript(pubkeys)))
+	}
+	return msB.Params.fee(estimateSize(p2pkhIns(1), txouts))
+}
+
+// pkScript locks a txout with M of the provided pubkeys
+// M pubkey pubkey pubkey N OP_CHECKMULTISIG
+func (msB *MultiSigBuilder) pkScript(pubkeys [][]byte) []byte {
+	scriptBuilder := btcscript.NewScriptBuilder().AddInt64(msB.M)
+	for _, pk := range pubkeys {
+		scriptBuilder = scriptBuilder.AddData(pk)
+	}
+	scriptBuilder = scriptBuilder.AddInt64(msB.N).AddOp(btcscript.OP_CHECKMULTISIG)
+	return scriptBuilder.Script()
+}
+
+// The multisig txouts this creates are never used to fund other builders.
+// Sweep them back with a MultiSigSpender.
 func (msB *MultiSigBuilder) Build() (*btcwire.MsgTx, error) {
+	if err := msB.Validate(); err != nil {
+		return nil, err
+	}
+	if msB.eachOutVal() < msB.Params.DustAmnt {
+		return nil, errors.New("Outputs would be under the dust limit")
+	}
 
 	utxo, err := specificUnspent(msB.SatNeeded(), msB.Params)
 	if err != nil {
@@ -55,29 +133,23 @@ func (msB *MultiSigBuilder) Build() (*btcwire.MsgTx, error) {
 	msgtx.AddTxIn(txin)
 
 	for _, pubkeys := range msB.PubKeyList {
-		// M pubkey pubkey pubkey N OP_CHECKMULTISIG
-		scriptBuilder := btcscript.NewScriptBuilder().AddInt64(msB.M)
-		for _, pk := range pubkeys {
-			scriptBuilder = scriptBuilder.AddData(pk)
-		}
-		scriptBuilder = scriptBuilder.AddInt64(msB.N).AddOp(btcscript.OP_CHECKMULTISIG)
-		PkScript := scriptBuilder.Script()
-		txout := btcwire.NewTxOut(msB.eachOutVal(), PkScript)
+		txout := btcwire.NewTxOut(msB.eachOutVal(), msB.pkScript(pubkeys))
 		msgtx.AddTxOut(txout)
 	}
 
 	// Sign this puppy
-	privkey := utxo.Wif.PrivKey
 	subscript := utxo.TxOut.PkScript
 	sigflag := btcscript.SigHashAll
-	scriptSig, err := btcscript.SignatureScript(msgtx, 0, subscript,
-		sigflag, privkey, true)
+	scriptSig, err := msB.Params.signer().Sign(msgtx, 0, subscript, sigflag)
 	if err != nil {
-		return nil, err
+		return nil, releaseErr(err, msB.Params, utxo)
 	}
 
 	msgtx.TxIn[0].SignatureScript = scriptSig
 
+	if err := msB.Params.verify(msgtx, utxo); err != nil {
+		return nil, releaseErr(err, msB.Params, utxo)
+	}
 	return msgtx, nil
 }
 
@@ -88,33 +160,55 @@ func (msB *MultiSigBuilder) Log(msg string) {
 }
 
 func (msB *MultiSigBuilder) Summarize() string {
-	s := "==== MuliSig ====\nSatNeeded:\t%d\nTxIns:\t1\nTxOuts:\t%d\n"
-	return fmt.Sprintf(s, msB.SatNeeded(), len(msB.PubKeyList))
+	s := "==== MuliSig ====\nSatNeeded:\t%d\nTxIns:\t1\nTxOuts:\t%d\nFeeRate:\t%d\n"
+	return fmt.Sprintf(s, msB.SatNeeded(), len(msB.PubKeyList), msB.Params.feeRate())
 }
 
+// CreateList packs data into three 65 byte key slots per txout after keys. It
+// returns nil when the keys leave no room for data.
 func CreateList(data []byte, keys ...*btcutil.WIF) [][][]byte {
-	dataOuts := int(math.Ceil(float64(len(data)) / 65))
-	numTxOuts := (dataOuts + len(keys)) / 3
+	list, err := CreateListN(data, 3, uncompressedKeySize, keys...)
+	if err != nil {
+		return nil
+	}
+	return list
+}
+
+// CreateListN builds a PubKeyList for a MultiSigBuilder where every entry
+// holds n keys: the real keys followed by data cut into slotSize chunks. The
+// last chunk is padded with zeros.
+func CreateListN(data []byte, n, slotSize int, keys ...*btcutil.WIF) ([][][]byte, error) {
+	if n < 1 || n > maxMultiSigKeys {
+		return nil, fmt.Errorf("N is %d, it must be between 1 and %d", n, maxMultiSigKeys)
+	}
+	if slotSize != compressedKeySize && slotSize != uncompressedKeySize {
+		return nil, fmt.Errorf("Slots are %d bytes, not %d or %d", slotSize, compressedKeySize, uncompressedKeySize)
+	}
+	dataSlots := n - len(keys)
+	if dataSlots < 1 {
+		return nil, fmt.Errorf("%d keys leave no room for data in %d slots", len(keys), n)
+	}
+
+	numChunks := (len(data) + slotSize - 1) / slotSize
+	numTxOuts := (numChunks + dataSlots - 1) / dataSlots
 	outMatrix := make([][][]byte, numTxOuts)
 
 	for i := 0; i < numTxOuts; i++ {
-		txOutData := make([][]byte, 3)
+		txOutData := make([][]byte, n)
 
 		// copy keys in
 		for j, key := range keys {
 			txOutData[j] = key.SerializePubKey()
 		}
 
-		for k := len(keys); k < 3; k++ {
+		for k := len(keys); k < n; k++ {
 			// copy data into dest
-			dest := make([]byte, 65, 65)
-			if len(data) > 0 {
-				m := copy(dest, data)
-				data = data[m:]
-			}
+			dest := make([]byte, slotSize)
+			m := copy(dest, data)
+			data = data[m:]
 			txOutData[k] = dest
 		}
 		outMatrix[i] = txOutData
 	}
-	return outMatrix
+	return outMatrix, nil
 }
@@ -0,0 +1,151 @@
+package btcbuilder
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/conformal/btcscript"
+	"github.com/conformal/btcutil"
+	"github.com/conformal/btcwire"
+)
+
+// MultiSigSpender sweeps bare m of n multisig txouts, like the ones
+// MultiSigBuilder creates, back to Addr. Signers are asked in order and must
+// be listed in the order their keys appear in the txouts.
+type MultiSigSpender struct {
+	Params  BuilderParams
+	Addr    btcutil.Address
+	Outs    []*TxInParams // Left nil every multisig unspent the Signers can spend is collected
+	Signers []Signer
+}
+
+func NewMultiSigSpender(params BuilderParams, addr btcutil.Address, signers ...Signer) *MultiSigSpender {
+	msSpB := MultiSigSpender{
+		Params:  params,
+		Addr:    addr,
+		Signers: signers,
+	}
+	return &msSpB
+}
+
+// SatNeeded is zero since the tx is funded by the multisig txouts it sweeps.
+func (msSpB *MultiSigSpender) SatNeeded() int64 {
+	return 0
+}
+
+func (msSpB *MultiSigSpender) signers() []Signer {
+	if len(msSpB.Signers) < 1 {
+		return []Signer{msSpB.Params.signer()}
+	}
+	return msSpB.Signers
+}
+
+func (msSpB *MultiSigSpender) Build() (*btcwire.MsgTx, error) {
+	outs := msSpB.Outs
+	if outs == nil {
+		var err error
+		if outs, err = msSpB.collect(); err != nil {
+			return nil, err
+		}
+	} else {
+		for _, out := range outs {
+			if btcscript.GetScriptClass(out.TxOut.PkScript) != btcscript.MultiSigTy {
+				return nil, fmt.Errorf("Unspent %s is not bare multisig", outPointStr(out.OutPoint))
+			}
+		}
+		if err := reserveOuts(outs, msSpB.Params); err != nil {
+			return nil, err
+		}
+	}
+	if len(outs) < 1 {
+		return nil, errors.New("No multisig outputs to spend")
+	}
+
+	sign := func(msgtx *btcwire.MsgTx) error {
+		for i, out := range outs {
+			scriptSig, err := multiSigScriptSig(msgtx, i, out.TxOut.PkScript, msSpB.signers())
+			if err != nil {
+				return err
+			}
+			msgtx.TxIn[i].SignatureScript = scriptSig
+		}
+		return nil
+	}
+	msgtx, err := sweepTx(outs, msSpB.Addr, sign, msSpB.Params)
+	if err != nil {
+		return nil, releaseErr(err, msSpB.Params, outs...)
+	}
+	return msgtx, nil
+}
+
+// collect reserves every multisig unspent in the params' source that the
+// Signers hold enough keys for.
+func (msSpB *MultiSigSpender) collect() ([]*TxInParams, error) {
+	list, err := msSpB.Params.source().ListUnspent()
+	if err != nil {
+		return nil, err
+	}
+	pick := func(available []*TxInParams) ([]*TxInParams, error) {
+		outs := make([]*TxInParams, 0)
+		for _, utxo := range available {
+			if btcscript.GetScriptClass(utxo.TxOut.PkScript) == btcscript.MultiSigTy && msSpB.canSign(utxo) {
+				outs = append(outs, utxo)
+			}
+		}
+		return outs, nil
+	}
+	return msSpB.Params.reservations().ReserveSelected(list, pick)
+}
+
+// canSign tries the Signers on a throwaway tx that spends just utxo.
+func (msSpB *MultiSigSpender) canSign(utxo *TxInParams) bool {
+	msgtx := btcwire.NewMsgTx()
+	msgtx.AddTxIn(btcwire.NewTxIn(utxo.OutPoint, []byte{}))
+	msgtx.AddTxOut(btcwire.NewTxOut(utxo.TxOut.Value, utxo.TxOut.PkScript))
+	_, err := multiSigScriptSig(msgtx, 0, utxo.TxOut.PkScript, msSpB.signers())
+	return err == nil
+}
+
+// multiSigScriptSig collects signatures for the multisig script pkScript
+// from signers until there are enough and returns the scriptSig.
+// OP_0 <sig> ... <sig>
+func multiSigScriptSig(msgtx *btcwire.MsgTx, idx int, pkScript []byte, signers []Signer) ([]byte, error) {
+	// The script opens with OP_M
+	nRequired := int(pkScript[0]) - (btcscript.OP_1 - 1)
+	sigs := make([][]byte, 0, nRequired)
+	for _, signer := range signers {
+		partial, err := signer.Sign(msgtx, idx, pkScript, btcscript.SigHashAll)
+		if err != nil {
+			// This signer holds none of the keys
+			continue
+		}
+		pushed, err := btcscript.PushedData(partial)
+		if err != nil {
+			return nil, err
+		}
+		for _, sig := range pushed {
+			if len(sig) > 0 && len(sigs) < nRequired {
+				sigs = append(sigs, sig)
+			}
+		}
+	}
+	if len(sigs) < nRequired {
+		return nil, fmt.Errorf("Input %d has %d of the %d signatures it needs", idx, len(sigs), nRequired)
+	}
+
+	// OP_CHECKMULTISIG pops one item too many, hence the OP_0
+	scriptBuilder := btcscript.NewScriptBuilder().AddOp(btcscript.OP_0)
+	for _, sig := range sigs {
+		scriptBuilder = scriptBuilder.AddData(sig)
+	}
+	return scriptBuilder.Script(), nil
+}
+
+func (msSpB *MultiSigSpender) Log(msg string) {
+	msSpB.Params.Logger.Println(msg)
+}
+
+func (msSpB *MultiSigSpender) Summarize() string {
+	s := "==== Spend MultiSig ====\nSatNeeded:\t%d\nTxIns:\t?\nTxOuts:\t1\nFeeRate:\t%d\n"
+	return fmt.Sprintf(s, msSpB.SatNeeded(), msSpB.Params.feeRate())
+}
@@ -8,10 +8,27 @@ import (
 	"github.com/conformal/btcwire"
 )
 
+// The largest single push the script engine allows, which caps any payload.
+const maxPushSize = 520
+
+// A NullDataBuilder stores Data in OP_RETURN txouts. It funds itself from
+// whatever unspents the params' CoinSelector picks and returns what is left
+// over as change whenever that is worth more than dust. Data that does not fit
+// in one txout is spread over as many as the params' Policy allows in a tx,
+// in order.
 type NullDataBuilder struct {
 	Params BuilderParams
 	Data   []byte
-	Change bool
+	Change bool  // Fund enough to be sure of a change output
+	Burn   int64 // The value locked in the OP_RETURN txout for good
+	// MaxPayload caps the size of Data. Zero defers to the params' Policy or
+	// StandardPolicy when there is none. Anything over the Policy's
+	// MaxDataCarrier will not be relayed by nodes that enforce it.
+	MaxPayload int
+
+	// The fee and txin and txout counts of the last tx built
+	paid            int64
+	numIns, numOuts int
 }
 
 func NewNullData(params BuilderParams, data []byte, change bool) *NullDataBuilder {
@@ -23,56 +40,127 @@ func NewNullData(params BuilderParams, data []byte, change bool) *NullDataBuilde
 	return &ndB
 }
 
-func (ndB *NullDataBuilder) SatNeeded() (sum int64) {
-	sum = 0
+func (ndB *NullDataBuilder) SatNeeded() int64 {
+	return ndB.need(p2pkhIns(1))
+}
+
+// need is what a tx that spends prevScripts has to be funded with
+func (ndB *NullDataBuilder) need(prevScripts [][]byte) int64 {
+	sum := ndB.Burn + ndB.fee(prevScripts, ndB.Change)
 	if ndB.Change {
-		sum = ndB.Params.InTarget
-	} else {
-		sum = ndB.Params.DustAmnt + ndB.Params.Fee
+		sum += ndB.Params.DustAmnt
 	}
 	return sum
 }
 
+// maxPayload is the most Data the builder will put in a tx.
+func (ndB *NullDataBuilder) maxPayload() int {
+	max := StandardPolicy.MaxDataCarrier
+	switch {
+	case ndB.MaxPayload > 0:
+		max = ndB.MaxPayload
+	case ndB.Params.Policy != nil:
+		max = ndB.Params.Policy.MaxDataCarrier
+	}
+	if max > maxPushSize {
+		return maxPushSize
+	}
+	return max
+}
+
+// maxOutputs is the most OP_RETURN txouts the builder will spread Data over.
+func (ndB *NullDataBuilder) maxOutputs() int {
+	if ndB.Params.Policy != nil && ndB.Params.Policy.MaxDataOutputs > 0 {
+		return ndB.Params.Policy.MaxDataOutputs
+	}
+	return StandardPolicy.MaxDataOutputs
+}
+
+// pushes cuts Data into the payloads of its OP_RETURN txouts.
+func (ndB *NullDataBuilder) pushes() [][]byte {
+	max := ndB.maxPayload()
+	data := ndB.Data
+	pushes := [][]byte{}
+	for max > 0 && len(data) > max {
+		pushes = append(pushes, data[:max])
+		data = data[max:]
+	}
+	return append(pushes, data)
+}
+
+// fee covers the inputs spending prevScripts, the OP_RETURN outputs and
+// change if there is any
+func (ndB *NullDataBuilder) fee(prevScripts [][]byte, change bool) int64 {
+	txouts := ndB.dataOuts()
+	if change {
+		txouts = append(txouts, p2pkhOuts(1)...)
+	}
+	return ndB.Params.fee(estimateSize(prevScripts, txouts))
+}
+
+// dataOuts pushes Data behind OP_RETURNs. The first txout locks up the Burn.
+func (ndB *NullDataBuilder) dataOuts() []*btcwire.TxOut {
+	pushes := ndB.pushes()
+	txouts := make([]*btcwire.TxOut, len(pushes))
+	for i, push := range pushes {
+		var value int64
+		if i == 0 {
+			value = ndB.Burn
+		}
+		retbuilder := btcscript.NewScriptBuilder().AddOp(btcscript.OP_RETURN).AddData(push)
+		txouts[i] = btcwire.NewTxOut(value, retbuilder.Script())
+	}
+	return txouts
+}
+
 func (ndB *NullDataBuilder) Build() (*btcwire.MsgTx, error) {
+	if max, outs := ndB.maxPayload(), ndB.maxOutputs(); len(ndB.Data) > max*outs {
+		return nil, fmt.Errorf("Data is %d bytes, over the %d byte limit of %d OP_RETURN outputs. Chunk it with NewNullDataChunks", len(ndB.Data), max, outs)
+	}
+	if ndB.Burn < 0 {
+		return nil, errors.New("Burn can not be negative")
+	}
 
-	utxo, err := specificUnspent(ndB.SatNeeded(), ndB.Params)
+	inParamSet, totalIn, err := composeFunded(ndB.need, ndB.Params)
 	if err != nil {
 		return nil, err
 	}
+	prevScripts := inScripts(inParamSet)
 
 	msgtx := btcwire.NewMsgTx()
-
-	if len(ndB.Data) > 40 {
-		return nil, errors.New("Data is too long to make this a standard tx.")
+	for _, inpParam := range inParamSet {
+		msgtx.AddTxIn(btcwire.NewTxIn(inpParam.OutPoint, []byte{}))
 	}
 
-	// OP Return output
-	retbuilder := btcscript.NewScriptBuilder().AddOp(btcscript.OP_RETURN).AddData(ndB.Data)
-	op_return := btcwire.NewTxOut(0, retbuilder.Script())
-	msgtx.AddTxOut(op_return)
+	// OP Return outputs
+	for _, txout := range ndB.dataOuts() {
+		msgtx.AddTxOut(txout)
+	}
 
-	if ndB.Change {
-		// change ouput
-		addr, _ := newAddr(ndB.Params.Client)
-		change, ok := changeOutput(ndB.SatNeeded()-ndB.Params.Fee, ndB.Params.DustAmnt, addr)
-		if !ok {
-			return nil, errors.New("Not enough for change")
+	// Whatever is left after the burn and the fee of a tx with change goes
+	// back to us. Less than dust is not worth a txout and goes to the miners.
+	changeval := totalIn - ndB.Burn - ndB.fee(prevScripts, true)
+	if changeval >= ndB.Params.DustAmnt {
+		change, err := makeChange(changeval, ndB.Params)
+		if err != nil {
+			return nil, releaseErr(err, ndB.Params, inParamSet...)
 		}
 		msgtx.AddTxOut(change)
+	} else if ndB.Change {
+		return nil, releaseErr(errors.New("Not enough for change"), ndB.Params, inParamSet...)
 	}
 
-	// funding input
-	txin := btcwire.NewTxIn(utxo.OutPoint, []byte{})
-	msgtx.AddTxIn(txin)
-
 	// sign msgtx
-	privkey := utxo.Wif.PrivKey
-	scriptSig, err := btcscript.SignatureScript(msgtx, 0, utxo.TxOut.PkScript, btcscript.SigHashAll, privkey, true)
+	err = signInputs(msgtx, inParamSet, btcscript.SigHashAll, ndB.Params)
 	if err != nil {
-		return nil, err
+		return nil, releaseErr(err, ndB.Params, inParamSet...)
 	}
-	txin.SignatureScript = scriptSig
 
+	if err := ndB.Params.verify(msgtx, inParamSet...); err != nil {
+		return nil, releaseErr(err, ndB.Params, inParamSet...)
+	}
+	ndB.paid = totalIn - sumOutputs(msgtx)
+	ndB.numIns, ndB.numOuts = len(msgtx.TxIn), len(msgtx.TxOut)
 	return msgtx, nil
 }
 
@@ -80,11 +168,16 @@ func (ndB *NullDataBuilder) Log(msg string) {
 	ndB.Params.Logger.Println(msg)
 }
 
+// Summarize reports on the last tx built, or estimates one before that.
 func (ndB *NullDataBuilder) Summarize() string {
-	s := "==== NullData ====\nSatNeeded:\t%d\nTxIns:\t1\nTxOuts:\t%d\nLenData:\t%d\n"
-	numouts := 1
-	if ndB.Change {
-		numouts = 2
+	s := "==== NullData ====\nSatNeeded:\t%d\nTxIns:\t%d\nTxOuts:\t%d\nLenData:\t%d\nBurned:\t%d\nFee:\t%d\nFeeRate:\t%d\n"
+	fee, numins, numouts := ndB.paid, ndB.numIns, ndB.numOuts
+	if numins == 0 {
+		numins, numouts = 1, len(ndB.pushes())
+		if ndB.Change {
+			numouts++
+		}
+		fee = ndB.fee(p2pkhIns(1), ndB.Change)
 	}
-	return fmt.Sprintf(s, ndB.SatNeeded(), numouts, len(ndB.Data))
+	return fmt.Sprintf(s, ndB.SatNeeded(), numins, numouts, len(ndB.Data), ndB.Burn, fee, ndB.Params.feeRate())
 }
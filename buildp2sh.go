@@ -0,0 +1,145 @@
+package btcbuilder
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/conformal/btcscript"
+	"github.com/conformal/btcutil"
+	"github.com/conformal/btcwire"
+)
+
+// The largest redeem script the script engine will push.
+const maxRedeemScriptSize = 520
+
+// A P2SHOutput is a pay to script hash txout together with the redeem script
+// that unlocks it. Keep these around: without the redeem script the txout can
+// never be spent.
+type P2SHOutput struct {
+	*TxInParams
+	RedeemScript []byte
+	// Unlock, when set, produces the pushes that satisfy RedeemScript. It is
+	// needed for scripts signers cannot handle on their own, like hash locks.
+	Unlock func(msgtx *btcwire.MsgTx, idx int) ([]byte, error)
+}
+
+// P2SHBuilder pays Amnt to the hash of each of RedeemScripts. The scripts can
+// be anything, multisig escrows and time or hash locks included.
+type P2SHBuilder struct {
+	Params        BuilderParams
+	Amnt          int64 // The value of every P2SH txout
+	RedeemScripts [][]byte
+}
+
+func NewP2SHBuilder(params BuilderParams, amnt int64, redeemScripts ...[]byte) *P2SHBuilder {
+	p2shB := P2SHBuilder{
+		Params:        params,
+		Amnt:          amnt,
+		RedeemScripts: redeemScripts,
+	}
+	return &p2shB
+}
+
+func (p2shB *P2SHBuilder) SatNeeded() int64 {
+	return int64(len(p2shB.RedeemScripts))*p2shB.Amnt + p2shB.fee()
+}
+
+// fee covers a single input, a P2SH txout for every redeem script and change
+func (p2shB *P2SHBuilder) fee() int64 {
+	txouts := p2pkhOuts(1)
+	for range p2shB.RedeemScripts {
+		// OP_HASH160 <20 bytes> OP_EQUAL
+		txouts = append(txouts, btcwire.NewTxOut(0, make([]byte, 23)))
+	}
+	return p2shB.Params.fee(estimateSize(p2pkhIns(1), txouts))
+}
+
+// pkScript locks a txout to the hash of redeemScript
+func (p2shB *P2SHBuilder) pkScript(redeemScript []byte) ([]byte, error) {
+	addr, err := btcutil.NewAddressScriptHash(redeemScript, p2shB.Params.NetParams)
+	if err != nil {
+		return nil, err
+	}
+	return btcscript.PayToAddrScript(addr)
+}
+
+func (p2shB *P2SHBuilder) Build() (*btcwire.MsgTx, error) {
+	msgtx, _, err := p2shB.BuildP2SH()
+	return msgtx, err
+}
+
+// BuildP2SH builds the tx and hands back every P2SH txout in it along with
+// its redeem script, in the order of RedeemScripts.
+func (p2shB *P2SHBuilder) BuildP2SH() (*btcwire.MsgTx, []*P2SHOutput, error) {
+	if len(p2shB.RedeemScripts) < 1 {
+		return nil, nil, errors.New("No redeem scripts to pay to")
+	}
+	if p2shB.Amnt < p2shB.Params.DustAmnt {
+		return nil, nil, errors.New("Output would be under the dust limit")
+	}
+	pkScripts := make([][]byte, len(p2shB.RedeemScripts))
+	for i, redeemScript := range p2shB.RedeemScripts {
+		if len(redeemScript) > maxRedeemScriptSize {
+			return nil, nil, fmt.Errorf("Redeem script %d is %d bytes, over %d", i, len(redeemScript), maxRedeemScriptSize)
+		}
+		pkScript, err := p2shB.pkScript(redeemScript)
+		if err != nil {
+			return nil, nil, err
+		}
+		pkScripts[i] = pkScript
+	}
+
+	utxo, err := selectUnspent(p2shB.SatNeeded(), p2shB.Params)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	msgtx := btcwire.NewMsgTx()
+	txin := btcwire.NewTxIn(utxo.OutPoint, []byte{})
+	msgtx.AddTxIn(txin)
+
+	for _, pkScript := range pkScripts {
+		msgtx.AddTxOut(btcwire.NewTxOut(p2shB.Amnt, pkScript))
+	}
+
+	changeval := utxo.TxOut.Value - p2shB.SatNeeded()
+	if changeval > p2shB.Params.DustAmnt {
+		change, err := makeChange(changeval, p2shB.Params)
+		if err != nil {
+			return nil, nil, releaseErr(err, p2shB.Params, utxo)
+		}
+		msgtx.AddTxOut(change)
+	}
+
+	scriptSig, err := p2shB.Params.signer().Sign(msgtx, 0, utxo.TxOut.PkScript, btcscript.SigHashAll)
+	if err != nil {
+		return nil, nil, releaseErr(err, p2shB.Params, utxo)
+	}
+	txin.SignatureScript = scriptSig
+
+	if err := p2shB.Params.verify(msgtx, utxo); err != nil {
+		return nil, nil, releaseErr(err, p2shB.Params, utxo)
+	}
+
+	hash := btcutil.NewTx(msgtx).Sha()
+	outs := make([]*P2SHOutput, len(p2shB.RedeemScripts))
+	for i, redeemScript := range p2shB.RedeemScripts {
+		outs[i] = &P2SHOutput{
+			TxInParams: &TxInParams{
+				TxOut:    msgtx.TxOut[i],
+				OutPoint: btcwire.NewOutPoint(hash, uint32(i)),
+			},
+			RedeemScript: redeemScript,
+		}
+	}
+	return msgtx, outs, nil
+}
+
+func (p2shB *P2SHBuilder) Log(msg string) {
+	p2shB.Params.Logger.Println(msg)
+}
+
+func (p2shB *P2SHBuilder) Summarize() string {
+	s := "==== Pay to Script Hash ====\nSatNeeded:\t%d\nTxIns:\t1\nTxOuts:\t%d\nFeeRate:\t%d\n"
+	return fmt.Sprintf(s, p2shB.SatNeeded(), len(p2shB.RedeemScripts)+1, p2shB.Params.feeRate())
+}
@@ -0,0 +1,104 @@
+package btcbuilder
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/conformal/btcscript"
+	"github.com/conformal/btcutil"
+	"github.com/conformal/btcwire"
+)
+
+// P2SHSpender sweeps P2SH txouts to Addr. Each input is signed against its
+// redeem script by Signers which are asked in order. For a multisig redeem
+// script list the Signers in the order their keys appear in the script.
+type P2SHSpender struct {
+	Params  BuilderParams
+	Addr    btcutil.Address
+	Outs    []*P2SHOutput
+	Signers []Signer
+}
+
+func NewP2SHSpender(params BuilderParams, addr btcutil.Address, outs []*P2SHOutput, signers ...Signer) *P2SHSpender {
+	spB := P2SHSpender{
+		Params:  params,
+		Addr:    addr,
+		Outs:    outs,
+		Signers: signers,
+	}
+	return &spB
+}
+
+// SatNeeded is zero since the tx is funded by Outs, not the params' source.
+func (spB *P2SHSpender) SatNeeded() int64 {
+	return 0
+}
+
+func (spB *P2SHSpender) Build() (*btcwire.MsgTx, error) {
+	if len(spB.Outs) < 1 {
+		return nil, errors.New("No P2SH outputs to spend")
+	}
+	ins := make([]*TxInParams, len(spB.Outs))
+	for i, out := range spB.Outs {
+		ins[i] = out.TxInParams
+	}
+	if err := reserveOuts(ins, spB.Params); err != nil {
+		return nil, err
+	}
+	// Redeem scripts vary too much to estimate the fee from
+	msgtx, err := sweepTx(ins, spB.Addr, spB.sign, spB.Params)
+	if err != nil {
+		return nil, releaseErr(err, spB.Params, ins...)
+	}
+	return msgtx, nil
+}
+
+func (spB *P2SHSpender) sign(msgtx *btcwire.MsgTx) error {
+	for i, out := range spB.Outs {
+		var pushes []byte
+		var err error
+		if out.Unlock != nil {
+			pushes, err = out.Unlock(msgtx, i)
+		} else {
+			pushes, err = spB.signRedeem(msgtx, i, out.RedeemScript)
+		}
+		if err != nil {
+			return err
+		}
+		// The redeem script goes last
+		redeem := btcscript.NewScriptBuilder().AddData(out.RedeemScript).Script()
+		msgtx.TxIn[i].SignatureScript = append(pushes, redeem...)
+	}
+	return nil
+}
+
+// signRedeem gets the Signers to satisfy redeemScript. Multisig scripts take
+// signatures from as many Signers as needed, anything else is signed by the
+// first Signer able to.
+func (spB *P2SHSpender) signRedeem(msgtx *btcwire.MsgTx, idx int, redeemScript []byte) ([]byte, error) {
+	if len(spB.Signers) < 1 {
+		return nil, errors.New("No signers to spend with")
+	}
+	if btcscript.GetScriptClass(redeemScript) != btcscript.MultiSigTy {
+		var err error
+		for _, signer := range spB.Signers {
+			var scriptSig []byte
+			scriptSig, err = signer.Sign(msgtx, idx, redeemScript, btcscript.SigHashAll)
+			if err == nil {
+				return scriptSig, nil
+			}
+		}
+		return nil, err
+	}
+
+	return multiSigScriptSig(msgtx, idx, redeemScript, spB.Signers)
+}
+
+func (spB *P2SHSpender) Log(msg string) {
+	spB.Params.Logger.Println(msg)
+}
+
+func (spB *P2SHSpender) Summarize() string {
+	s := "==== Spend Pay to Script Hash ====\nSatNeeded:\t%d\nTxIns:\t%d\nTxOuts:\t1\nFeeRate:\t%d\n"
+	return fmt.Sprintf(s, spB.SatNeeded(), len(spB.Outs), spB.Params.feeRate())
+}
@@ -24,19 +24,25 @@ func NewPayToPubKeyHash(params BuilderParams, numouts int64) *PubKeyHashBuilder
 // pkhbuilders are strict about there inTarget
 // If num outs does not form a valid amount we round down
 func (pkhB *PubKeyHashBuilder) SatNeeded() int64 {
-	return pkhB.eachOutVal()*pkhB.NumOuts + pkhB.Params.Fee
+	return pkhB.eachOutVal()*pkhB.NumOuts + pkhB.fee()
 }
 
 // the amount sent to each output
 func (pkhB *PubKeyHashBuilder) eachOutVal() int64 {
 	n := pkhB.NumOuts
 	t := pkhB.Params.InTarget
-	f := pkhB.Params.Fee
+	f := pkhB.fee()
 
 	each := (t - f) / n
 	return each
 }
 
+// fee covers a single input and NumOuts pay to pubkey hash outputs
+func (pkhB *PubKeyHashBuilder) fee() int64 {
+	size := estimateSize(p2pkhIns(1), p2pkhOuts(pkhB.NumOuts))
+	return pkhB.Params.fee(size)
+}
+
 func (pkhB *PubKeyHashBuilder) Build() (*btcwire.MsgTx, error) {
 
 	inparams, err := specificUnspent(pkhB.SatNeeded(), pkhB.Params)
@@ -50,30 +56,30 @@ func (pkhB *PubKeyHashBuilder) Build() (*btcwire.MsgTx, error) {
 	msgtx.AddTxIn(txin)
 
 	for i := int64(0); i < pkhB.NumOuts; i++ {
-		addr, err := newAddr(pkhB.Params.Client)
+		addr, err := newAddr(pkhB.Params)
 		if err != nil {
-			return nil, err
+			return nil, releaseErr(err, pkhB.Params, inparams)
 		}
 		addrScript, err := btcscript.PayToAddrScript(addr)
 		amntSend := pkhB.eachOutVal()
 		if amntSend < pkhB.Params.DustAmnt {
-			return nil, errors.New("Output would be under the dust limit")
+			return nil, releaseErr(errors.New("Output would be under the dust limit"), pkhB.Params, inparams)
 		}
 		txout := btcwire.NewTxOut(pkhB.eachOutVal(), addrScript)
 		msgtx.AddTxOut(txout)
 	}
-	privkey := inparams.Wif.PrivKey
-	sig, err := btcscript.SignatureScript(msgtx,
+	sig, err := pkhB.Params.signer().Sign(msgtx,
 		0,
 		inparams.TxOut.PkScript,
-		btcscript.SigHashAll,
-		privkey,
-		true)
+		btcscript.SigHashAll)
 	if err != nil {
-		return nil, err
+		return nil, releaseErr(err, pkhB.Params, inparams)
 	}
 	txin.SignatureScript = sig
 
+	if err := pkhB.Params.verify(msgtx, inparams); err != nil {
+		return nil, releaseErr(err, pkhB.Params, inparams)
+	}
 	return msgtx, nil
 }
 
@@ -82,6 +88,6 @@ func (pkhB *PubKeyHashBuilder) Log(msg string) {
 }
 
 func (pkhB *PubKeyHashBuilder) Summarize() string {
-	s := "==== Pay2PubKeyHash ====\nSatNeeded:\t%d\nTxIns:\t1\nTxOuts:\t%d\n"
-	return fmt.Sprintf(s, pkhB.SatNeeded(), pkhB.NumOuts)
+	s := "==== Pay2PubKeyHash ====\nSatNeeded:\t%d\nTxIns:\t1\nTxOuts:\t%d\nFeeRate:\t%d\n"
+	return fmt.Sprintf(s, pkhB.SatNeeded(), pkhB.NumOuts, pkhB.Params.feeRate())
 }
@@ -35,7 +35,7 @@ func (builder *ToAddrBuilder) Build() (*btcwire.MsgTx, error) {
 	msgtx := btcwire.NewMsgTx()
 	msgtx.AddTxIn(txin)
 	// add send to addr
-	valout := builder.Params.InTarget - builder.Params.Fee
+	valout := builder.Params.InTarget - builder.fee()
 	outscript, _ := btcscript.PayToAddrScript(builder.Addr)
 	txout := btcwire.NewTxOut(valout, outscript)
 
@@ -46,9 +46,9 @@ func (builder *ToAddrBuilder) Build() (*btcwire.MsgTx, error) {
 	changeval := total - builder.SatNeeded()
 	if changeval > builder.Params.DustAmnt {
 		// Change needed
-		changeAddr, err := builder.Params.Client.GetNewAddress()
+		changeAddr, err := newAddr(builder.Params)
 		if err != nil {
-			return nil, err
+			return nil, releaseErr(err, builder.Params, utxo)
 		}
 		change, ok := changeOutput(changeval, builder.Params.DustAmnt, changeAddr)
 		if ok {
@@ -57,13 +57,15 @@ func (builder *ToAddrBuilder) Build() (*btcwire.MsgTx, error) {
 	}
 
 	subscript := utxo.TxOut.PkScript
-	privkey := utxo.Wif.PrivKey
-	scriptSig, err := btcscript.SignatureScript(msgtx, 0, subscript, btcscript.SigHashAll, privkey, true)
+	scriptSig, err := builder.Params.signer().Sign(msgtx, 0, subscript, btcscript.SigHashAll)
 	if err != nil {
-		return nil, err
+		return nil, releaseErr(err, builder.Params, utxo)
 	}
 	txin.SignatureScript = scriptSig
 
+	if err := builder.Params.verify(msgtx, utxo); err != nil {
+		return nil, releaseErr(err, builder.Params, utxo)
+	}
 	return msgtx, nil
 }
 
@@ -71,9 +73,16 @@ func (taB *ToAddrBuilder) SatNeeded() int64 {
 	return taB.Params.InTarget
 }
 
+// fee covers a single input, the send to Addr and change
+func (taB *ToAddrBuilder) fee() int64 {
+	outscript, _ := btcscript.PayToAddrScript(taB.Addr)
+	txouts := append(p2pkhOuts(1), btcwire.NewTxOut(0, outscript))
+	return taB.Params.fee(estimateSize(p2pkhIns(1), txouts))
+}
+
 func (taB *ToAddrBuilder) Summarize() string {
-	s := "==== Send To Addr ====\nSatNeeded:\t%d\nTxIns:\t?\nTxOuts:\t2"
-	return fmt.Sprintf(s, taB.SatNeeded())
+	s := "==== Send To Addr ====\nSatNeeded:\t%d\nTxIns:\t?\nTxOuts:\t2\nFeeRate:\t%d\n"
+	return fmt.Sprintf(s, taB.SatNeeded(), taB.Params.feeRate())
 }
 
 func (taB *ToAddrBuilder) Log(msg string) {
@@ -28,7 +28,6 @@ func (shsB *SigHashSingleBuilder) Build() (*btcwire.MsgTx, error) {
 
 	oldTxOut := utxo.TxOut
 	outpoint := utxo.OutPoint
-	wifkey := utxo.Wif
 
 	// Transaction building
 
@@ -37,13 +36,17 @@ func (shsB *SigHashSingleBuilder) Build() (*btcwire.MsgTx, error) {
 	// notice amount in
 	total := oldTxOut.Value
 	changeval := total - (shsB.SatNeeded())
-	change, ok := changeOutput(changeval, shsB.Params.DustAmnt,
-		wifToAddr(wifkey, shsB.Params.NetParams))
+	// Change goes back to the address that funded the tx
+	addr, err := scriptAddr(oldTxOut.PkScript, shsB.Params.NetParams)
+	if err != nil {
+		return nil, releaseErr(err, shsB.Params, utxo)
+	}
+	change, ok := changeOutput(changeval, shsB.Params.DustAmnt, addr)
 	if !ok {
-		return nil, errors.New("Not enough for change.")
+		return nil, releaseErr(errors.New("Not enough for change."), shsB.Params, utxo)
 	}
 	// Blank permutable txout for users to play with
-	blankval := shsB.Params.InTarget - shsB.Params.Fee
+	blankval := shsB.Params.InTarget - shsB.fee()
 	blank := btcwire.NewTxOut(blankval, change.PkScript) //[]byte{})
 
 	msgtx := btcwire.NewMsgTx()
@@ -52,10 +55,9 @@ func (shsB *SigHashSingleBuilder) Build() (*btcwire.MsgTx, error) {
 	msgtx.AddTxOut(blank)
 
 	subscript := oldTxOut.PkScript
-	privkey := wifkey.PrivKey
-	scriptSig, err := btcscript.SignatureScript(msgtx, 0, subscript, btcscript.SigHashSingle, privkey, true)
+	scriptSig, err := shsB.Params.signer().Sign(msgtx, 0, subscript, btcscript.SigHashSingle)
 	if err != nil {
-		return nil, err
+		return nil, releaseErr(err, shsB.Params, utxo)
 	}
 
 	msgtx.TxIn[0].SignatureScript = scriptSig
@@ -63,6 +65,9 @@ func (shsB *SigHashSingleBuilder) Build() (*btcwire.MsgTx, error) {
 	//msgtx.TxOut[1].PkScript = oldTxOut.PkScript
 	blank.Value = blankval + 1
 
+	if err := shsB.Params.verify(msgtx, utxo); err != nil {
+		return nil, releaseErr(err, shsB.Params, utxo)
+	}
 	return msgtx, nil
 }
 
@@ -70,11 +75,17 @@ func (shsB *SigHashSingleBuilder) SatNeeded() int64 {
 	return shsB.Params.InTarget
 }
 
+// fee covers a single input, its change and the blank txout
+func (shsB *SigHashSingleBuilder) fee() int64 {
+	size := estimateSize(p2pkhIns(1), p2pkhOuts(2))
+	return shsB.Params.fee(size)
+}
+
 func (shsB *SigHashSingleBuilder) Log(msg string) {
 	shsB.Params.Logger.Println(msg)
 }
 
 func (shsB *SigHashSingleBuilder) Summarize() string {
-	s := "==== SigHashSingle ====\nSatNeeded:\t%d\nTxIns:\t?\nTxOuts:\t2"
-	return fmt.Sprintf(s, shsB.SatNeeded())
+	s := "==== SigHashSingle ====\nSatNeeded:\t%d\nTxIns:\t?\nTxOuts:\t2\nFeeRate:\t%d\n"
+	return fmt.Sprintf(s, shsB.SatNeeded(), shsB.Params.feeRate())
 }
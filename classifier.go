@@ -1,8 +1,10 @@
 package btcbuilder
 
 import (
+	"bytes"
 	"sort"
 
+	"github.com/conformal/btcec"
 	"github.com/conformal/btcscript"
 	"github.com/conformal/btcwire"
 )
@@ -24,32 +26,339 @@ type Pair struct {
 
 type PairList []Pair
 
-func (p PairList) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
-func (p PairList) Len() int           { return len(p) }
-func (p PairList) Less(i, j int) bool { return p[i].Num < p[j].Num }
+func (p PairList) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+func (p PairList) Len() int      { return len(p) }
+func (p PairList) Less(i, j int) bool {
+	if p[i].Num != p[j].Num {
+		return p[i].Num < p[j].Num
+	}
+	return p[i].Class < p[j].Class
+}
 
 // SelectKind picks from a set of known tx types a transactions `kind`
 // Which is the set of enumerated transaction we can identify based on
 // the properties of that transaction.
 func SelectKind(tx *btcwire.MsgTx) string {
 	counts := ExtractOutScripts(tx)
-	if len(counts) < 1 {
-		return "nonstandard"
+	if kind, ok := funkyKind(counts); ok {
+		return kind
+	}
+
+	// If the tx does not have funky output scripts just count occurrences,
+	// the rarest class wins
+	pl := pairs(counts)
+	sort.Sort(pl)
+	return pl[0].Class.String()
+}
+
+// funkyKind names the kind of a tx with an output script that decides it
+// whatever else the tx holds.
+func funkyKind(counts map[btcscript.ScriptClass]int) (string, bool) {
+	switch {
+	case len(counts) < 1 || counts[btcscript.NonStandardTy] > 0:
+		return "nonstandard", true
+	case counts[btcscript.NullDataTy] > 0:
+		return "nulldata", true
+	case counts[btcscript.MultiSigTy] > 0:
+		return "multisig", true
 	}
+	return "", false
+}
 
-	pl := make(PairList, 0)
+func pairs(counts map[btcscript.ScriptClass]int) PairList {
+	pl := make(PairList, 0, len(counts))
 	for cls, num := range counts {
-		switch {
-		case cls == btcscript.NonStandardTy:
-			return "nonstandard"
-		case cls == btcscript.NullDataTy:
-			return "nulldata"
-		case cls == btcscript.MultiSigTy:
-			return "multisig"
-		}
 		pl = append(pl, Pair{Num: num, Class: cls})
 	}
-	// If the tx does not have funky output scripts just count occurrences
-	sort.Sort(pl)
-	return pl[0].Class.String()
+	return pl
+}
+
+// commonKind is the kind Classify reports. Unlike SelectKind it goes by the
+// most common class, which is the one that says what the tx is for. Ties go
+// to the lowest class.
+func commonKind(tx *btcwire.MsgTx) string {
+	counts := ExtractOutScripts(tx)
+	if kind, ok := funkyKind(counts); ok {
+		return kind
+	}
+	best := Pair{Num: -1}
+	for _, p := range pairs(counts) {
+		if p.Num > best.Num || p.Num == best.Num && p.Class < best.Class {
+			best = p
+		}
+	}
+	return best.Class.String()
+}
+
+// A Pattern names the builder a tx looks like it came from.
+type Pattern string
+
+const (
+	PatternUnknown       Pattern = "unknown"
+	PatternDust          Pattern = "dust"
+	PatternFanOut        Pattern = "fanout"
+	PatternBulletin      Pattern = "bulletin"
+	PatternMultiSigData  Pattern = "multisigdata"
+	PatternNullData      Pattern = "nulldata"
+	PatternSigHashSingle Pattern = "sighashsingle"
+)
+
+// A Classification describes what a tx is made of and which builder most
+// likely made it.
+type Classification struct {
+	Kind       string                  // The most common class of txout, funky ones first
+	Outputs    []btcscript.ScriptClass // The class of every txout
+	Inputs     []btcscript.ScriptClass // The class each txin spends, read off its scriptSig
+	Coinbase   bool
+	SigHashes  []btcscript.SigHashType // The hash type of every signature in the scriptSigs
+	Pattern    Pattern
+	Confidence float64 // How sure the Pattern is, from 0 to 1
+}
+
+// patternTest scores how much a tx looks like one Pattern, zero for not at all.
+type patternTest struct {
+	pattern Pattern
+	score   func(c *Classification, tx *btcwire.MsgTx) float64
+}
+
+// The tests in the order they win ties.
+var patternTests = []patternTest{
+	{PatternSigHashSingle, scoreSigHashSingle},
+	{PatternMultiSigData, scoreMultiSigData},
+	{PatternBulletin, scoreBulletin},
+	{PatternNullData, scoreNullData},
+	{PatternDust, scoreDust},
+	{PatternFanOut, scoreFanOut},
+}
+
+// Classify breaks tx down by script class and guesses the builder behind it.
+// Inputs are judged by their scriptSigs alone since the txouts they spend are
+// not at hand, which leaves unsigned inputs NonStandardTy.
+func Classify(tx *btcwire.MsgTx) *Classification {
+	c := &Classification{
+		Kind:      commonKind(tx),
+		Outputs:   make([]btcscript.ScriptClass, len(tx.TxOut)),
+		Inputs:    make([]btcscript.ScriptClass, len(tx.TxIn)),
+		SigHashes: make([]btcscript.SigHashType, 0),
+		Pattern:   PatternUnknown,
+		Coinbase:  isCoinbase(tx),
+	}
+	for i, txout := range tx.TxOut {
+		c.Outputs[i] = btcscript.GetScriptClass(txout.PkScript)
+	}
+	if !c.Coinbase {
+		for i, txin := range tx.TxIn {
+			pushes, err := btcscript.PushedData(txin.SignatureScript)
+			if err != nil {
+				pushes = nil
+			}
+			c.Inputs[i] = inputClass(pushes)
+			for _, push := range pushes {
+				if isSignature(push) {
+					c.SigHashes = append(c.SigHashes, btcscript.SigHashType(push[len(push)-1]))
+				}
+			}
+		}
+	}
+
+	for _, test := range patternTests {
+		if score := test.score(c, tx); score > c.Confidence {
+			c.Pattern, c.Confidence = test.pattern, score
+		}
+	}
+	return c
+}
+
+func isCoinbase(tx *btcwire.MsgTx) bool {
+	if len(tx.TxIn) != 1 {
+		return false
+	}
+	prev := tx.TxIn[0].PreviousOutPoint
+	return prev.Index == ^uint32(0) && prev.Hash == btcwire.ShaHash{}
+}
+
+// inputClass guesses the class of the pkScript a scriptSig made of pushes
+// spends.
+func inputClass(pushes [][]byte) btcscript.ScriptClass {
+	if len(pushes) == 0 {
+		return btcscript.NonStandardTy
+	}
+	last := pushes[len(pushes)-1]
+	switch {
+	case len(pushes) == 1 && isSignature(last):
+		return btcscript.PubKeyTy
+	case len(pushes) == 2 && isSignature(pushes[0]) && isPubKey(last):
+		return btcscript.PubKeyHashTy
+	case len(pushes) >= 2 && !isSignature(last) && btcscript.GetScriptClass(last) != btcscript.NonStandardTy:
+		// The last push is the redeem script
+		return btcscript.ScriptHashTy
+	case len(pushes) >= 2 && len(pushes[0]) == 0:
+		// OP_0 for CHECKMULTISIG's extra pop, then signatures
+		for _, push := range pushes[1:] {
+			if !isSignature(push) {
+				return btcscript.NonStandardTy
+			}
+		}
+		return btcscript.MultiSigTy
+	}
+	return btcscript.NonStandardTy
+}
+
+// isSignature reports whether push looks like a DER signature followed by a
+// hash type.
+func isSignature(push []byte) bool {
+	return len(push) >= 9 && len(push) <= 73 && push[0] == 0x30 && int(push[1]) == len(push)-3
+}
+
+func isPubKey(push []byte) bool {
+	switch len(push) {
+	case compressedKeySize:
+		return push[0] == 0x02 || push[0] == 0x03
+	case uncompressedKeySize:
+		return push[0] == 0x04
+	}
+	return false
+}
+
+// SigHashSingleBuilder signs with SIGHASH_SINGLE where everything else uses
+// SIGHASH_ALL.
+func scoreSigHashSingle(c *Classification, tx *btcwire.MsgTx) float64 {
+	if len(c.SigHashes) == 0 {
+		return 0
+	}
+	single := 0
+	for _, hashType := range c.SigHashes {
+		if hashType&^btcscript.SigHashAnyOneCanPay == btcscript.SigHashSingle {
+			single++
+		}
+	}
+	switch {
+	case single == len(c.SigHashes):
+		return 0.95
+	case single > 0:
+		return 0.6
+	}
+	return 0
+}
+
+// CreateList fills the key slots of multisig txouts with data, which is
+// almost never a point on the curve.
+func scoreMultiSigData(c *Classification, tx *btcwire.MsgTx) float64 {
+	multisig, withData := 0, 0
+	for i, txout := range tx.TxOut {
+		if c.Outputs[i] != btcscript.MultiSigTy {
+			continue
+		}
+		multisig++
+		pubkeys, err := btcscript.PushedData(txout.PkScript)
+		if err != nil {
+			continue
+		}
+		for _, pk := range pubkeys {
+			if _, err := btcec.ParsePubKey(pk, btcec.S256()); err != nil {
+				withData++
+				break
+			}
+		}
+	}
+	switch {
+	case withData == 0:
+		return 0
+	case withData == multisig:
+		return 0.95
+	}
+	return 0.8
+}
+
+// Bulletins hide text in the hashes of pay to pubkey hash txouts that all
+// carry the same burn. Real hashes are rarely more than half printable.
+func scoreBulletin(c *Classification, tx *btcwire.MsgTx) float64 {
+	byValue := make(map[int64][][]byte)
+	for i, txout := range tx.TxOut {
+		if c.Outputs[i] != btcscript.PubKeyHashTy {
+			continue
+		}
+		pushed, err := btcscript.PushedData(txout.PkScript)
+		if err != nil || len(pushed) != 1 {
+			continue
+		}
+		byValue[txout.Value] = append(byValue[txout.Value], pushed[0])
+	}
+
+	var best float64
+	for _, hashes := range byValue {
+		if len(hashes) < 2 {
+			continue
+		}
+		data := bytes.Join(hashes, nil)
+		printable := 0
+		for _, b := range data {
+			if b >= 0x20 && b < 0x7f || b == '\n' {
+				printable++
+			}
+		}
+		if ratio := float64(printable) / float64(len(data)); ratio > best {
+			best = ratio
+		}
+	}
+	if best < 0.75 {
+		return 0
+	}
+	return 0.95 * best
+}
+
+func scoreNullData(c *Classification, tx *btcwire.MsgTx) float64 {
+	n := 0
+	for _, class := range c.Outputs {
+		if class == btcscript.NullDataTy {
+			n++
+		}
+	}
+	switch {
+	case n == 0:
+		return 0
+	case n == 1:
+		return 0.9
+	}
+	return 0.7
+}
+
+// DustBuilder pays dust to pay to pubkey hash txouts and takes no change.
+func scoreDust(c *Classification, tx *btcwire.MsgTx) float64 {
+	if len(tx.TxOut) < 2 {
+		return 0
+	}
+	for i, txout := range tx.TxOut {
+		if c.Outputs[i] != btcscript.PubKeyHashTy || txout.Value > StandardPolicy.DustThreshold || txout.Value != tx.TxOut[0].Value {
+			return 0
+		}
+	}
+	if len(tx.TxIn) == 1 {
+		return 0.9
+	}
+	return 0.75
+}
+
+// FanOutBuilder makes many txouts of one value above dust and maybe change.
+func scoreFanOut(c *Classification, tx *btcwire.MsgTx) float64 {
+	counts := make(map[int64]int)
+	for _, txout := range tx.TxOut {
+		if txout.Value > StandardPolicy.DustThreshold {
+			counts[txout.Value]++
+		}
+	}
+	copies := 0
+	for _, n := range counts {
+		if n > copies {
+			copies = n
+		}
+	}
+	if copies < 3 || copies < len(tx.TxOut)-1 {
+		return 0
+	}
+	score := 0.5 + 0.05*float64(copies)
+	if score > 0.9 {
+		score = 0.9
+	}
+	return score
 }
@@ -0,0 +1,281 @@
+// Command btcbuilder builds test transactions with the btcbuilder package
+// from the command line. Every builder is a subcommand, e.g.
+//
+//	btcbuilder --mode=summary dust --outs=5
+//	btcbuilder --fee=20 --mode=send nulldata --data="hello world"
+//
+// Funds and keys come from the bitcoind wallet described by bitcoin.conf.
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/NSkelsey/btcbuilder"
+	"github.com/NSkelsey/protocol/ahimsa"
+	"github.com/conformal/btcutil"
+	"github.com/jessevdk/go-flags"
+)
+
+type globalOpts struct {
+	Conf     string `long:"conf" description:"Path of the node's bitcoin.conf"`
+	Network  string `long:"net" description:"Network to build for: MainNet, TestNet3, TestNet (regtest) or SimNet. Must match the node"`
+	FeeRate  int64  `long:"fee" description:"Fee rate in satoshis per byte, overrides fee estimation"`
+	DustAmnt int64  `long:"dust" default:"546" description:"Value of a dust txout in satoshis"`
+	InTarget int64  `long:"intarget" default:"100000" description:"Value of the input builders that want a fixed input are funded with"`
+	Mode     string `long:"mode" default:"hex" choice:"hex" choice:"json" choice:"summary" choice:"send" description:"What to do with the tx"`
+}
+
+var opts globalOpts
+
+// params connects to the node and applies the global flags.
+func params() (btcbuilder.BuilderParams, error) {
+	options := []btcbuilder.Option{
+		btcbuilder.WithDust(opts.DustAmnt),
+		btcbuilder.WithInTarget(opts.InTarget),
+	}
+	if opts.Conf != "" {
+		options = append(options, btcbuilder.WithConf(opts.Conf))
+	}
+	if opts.Network != "" {
+		net, err := btcbuilder.NetParamsFromStr(opts.Network)
+		if err != nil {
+			return btcbuilder.BuilderParams{}, err
+		}
+		options = append(options, btcbuilder.WithNet(net))
+	}
+	if opts.FeeRate > 0 {
+		options = append(options, btcbuilder.WithFeeRate(opts.FeeRate))
+	}
+	return btcbuilder.NewParams(options...)
+}
+
+// txJson is what json mode prints.
+type txJson struct {
+	Txid string `json:"txid"`
+	Size int    `json:"size"`
+	Hex  string `json:"hex"`
+}
+
+// run does what --mode asks with the builder create makes.
+func run(create func(btcbuilder.BuilderParams) (btcbuilder.TxBuilder, error)) error {
+	params, err := params()
+	if err != nil {
+		return err
+	}
+	builder, err := create(params)
+	if err != nil {
+		return err
+	}
+
+	switch opts.Mode {
+	case "summary":
+		fmt.Print(builder.Summarize())
+		return nil
+	case "send":
+		hash, err := btcbuilder.Send(builder, params)
+		if err != nil {
+			return err
+		}
+		fmt.Println(hash)
+		return nil
+	}
+
+	msgtx, err := builder.Build()
+	if err != nil {
+		return err
+	}
+	if opts.Mode == "json" {
+		out := txJson{
+			Txid: btcutil.NewTx(msgtx).Sha().String(),
+			Size: msgtx.SerializeSize(),
+			Hex:  btcbuilder.ToHex(msgtx),
+		}
+		raw, err := json.MarshalIndent(out, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(raw))
+		return nil
+	}
+	fmt.Println(btcbuilder.ToHex(msgtx))
+	return nil
+}
+
+// dataOpts is shared by the builders that embed data.
+type dataOpts struct {
+	Data    string `long:"data" description:"The data to embed"`
+	HexData bool   `long:"hex" description:"Decode --data as hex"`
+}
+
+func (d dataOpts) bytes() ([]byte, error) {
+	if d.HexData {
+		return hex.DecodeString(d.Data)
+	}
+	return []byte(d.Data), nil
+}
+
+type dustCmd struct {
+	Outs int64 `long:"outs" default:"3" description:"Number of dust txouts"`
+}
+
+func (c *dustCmd) Execute(args []string) error {
+	return run(func(p btcbuilder.BuilderParams) (btcbuilder.TxBuilder, error) {
+		return btcbuilder.NewDustBuilder(p, c.Outs), nil
+	})
+}
+
+type pubKeyHashCmd struct {
+	Outs int64 `long:"outs" default:"2" description:"Number of pay to pubkey hash txouts"`
+}
+
+func (c *pubKeyHashCmd) Execute(args []string) error {
+	return run(func(p btcbuilder.BuilderParams) (btcbuilder.TxBuilder, error) {
+		return btcbuilder.NewPayToPubKeyHash(p, c.Outs), nil
+	})
+}
+
+type fanOutCmd struct {
+	Copies    int    `long:"copies" default:"10" description:"Number of txouts made for each child"`
+	Child     string `long:"child" default:"dust" choice:"dust" choice:"pubkeyhash" description:"The builder the txouts are sized for"`
+	ChildOuts int64  `long:"child-outs" default:"3" description:"Number of txouts the child builder makes"`
+}
+
+func (c *fanOutCmd) Execute(args []string) error {
+	return run(func(p btcbuilder.BuilderParams) (btcbuilder.TxBuilder, error) {
+		var child btcbuilder.TxBuilder
+		if c.Child == "pubkeyhash" {
+			child = btcbuilder.NewPayToPubKeyHash(p, c.ChildOuts)
+		} else {
+			child = btcbuilder.NewDustBuilder(p, c.ChildOuts)
+		}
+		return btcbuilder.NewFanOutBuilder(p, []btcbuilder.TxBuilder{child}, c.Copies), nil
+	})
+}
+
+type multiSigCmd struct {
+	dataOpts
+	M          int64    `long:"m" default:"1" description:"Signatures needed to spend each txout"`
+	N          int      `long:"n" default:"3" description:"Key slots in each txout"`
+	Compressed bool     `long:"compressed" description:"Use 33 byte data slots instead of 65"`
+	Wifs       []string `long:"wif" description:"A real key to put in every txout, may be repeated"`
+}
+
+func (c *multiSigCmd) Execute(args []string) error {
+	return run(func(p btcbuilder.BuilderParams) (btcbuilder.TxBuilder, error) {
+		data, err := c.bytes()
+		if err != nil {
+			return nil, err
+		}
+		keys := make([]*btcutil.WIF, len(c.Wifs))
+		for i, s := range c.Wifs {
+			if keys[i], err = btcutil.DecodeWIF(s); err != nil {
+				return nil, err
+			}
+		}
+		slotSize := 65
+		if c.Compressed {
+			slotSize = 33
+		}
+		list, err := btcbuilder.CreateListN(data, c.N, slotSize, keys...)
+		if err != nil {
+			return nil, err
+		}
+		builder := btcbuilder.NewMultiSigBuilder(p, c.M, list)
+		return builder, builder.Validate()
+	})
+}
+
+type nullDataCmd struct {
+	dataOpts
+	Change bool  `long:"change" description:"Fund enough to be sure of a change output"`
+	Burn   int64 `long:"burn" description:"Satoshis to lock in the OP_RETURN txout"`
+}
+
+func (c *nullDataCmd) Execute(args []string) error {
+	return run(func(p btcbuilder.BuilderParams) (btcbuilder.TxBuilder, error) {
+		data, err := c.bytes()
+		if err != nil {
+			return nil, err
+		}
+		builder := btcbuilder.NewNullData(p, data, c.Change)
+		builder.Burn = c.Burn
+		return builder, nil
+	})
+}
+
+type sendToAddrCmd struct {
+	Addr string `long:"addr" required:"true" description:"The address to pay"`
+}
+
+func (c *sendToAddrCmd) Execute(args []string) error {
+	return run(func(p btcbuilder.BuilderParams) (btcbuilder.TxBuilder, error) {
+		builder := btcbuilder.NewToAddrBuilder(p, c.Addr)
+		if builder.Addr == nil {
+			return nil, fmt.Errorf("%s is not a valid address", c.Addr)
+		}
+		return builder, nil
+	})
+}
+
+type sigHashSingleCmd struct{}
+
+func (c *sigHashSingleCmd) Execute(args []string) error {
+	return run(func(p btcbuilder.BuilderParams) (btcbuilder.TxBuilder, error) {
+		return btcbuilder.NewSigHashSingleBuilder(p), nil
+	})
+}
+
+type bulletinCmd struct {
+	Topic   string `long:"topic" description:"The bulletin's topic"`
+	Message string `long:"message" required:"true" description:"The bulletin's message"`
+	Burn    int64  `long:"burn" description:"Satoshis behind every txout, defaults to --dust"`
+}
+
+func (c *bulletinCmd) Execute(args []string) error {
+	return run(func(p btcbuilder.BuilderParams) (btcbuilder.TxBuilder, error) {
+		if c.Message == "" {
+			return nil, errors.New("A bulletin needs a message")
+		}
+		burn := c.Burn
+		if burn == 0 {
+			burn = p.DustAmnt
+		}
+		bltn := ahimsa.Bulletin{Topic: c.Topic, Message: c.Message}
+		return btcbuilder.NewBulletinBuilder(p, burn, bltn), nil
+	})
+}
+
+func main() {
+	parser := flags.NewParser(&opts, flags.Default)
+	commands := []struct {
+		name, desc string
+		cmd        interface{}
+	}{
+		{"dust", "Spend into dust txouts", &dustCmd{}},
+		{"fanout", "Split funds into txouts sized for another builder", &fanOutCmd{}},
+		{"multisig", "Embed data in bare multisig txouts", &multiSigCmd{}},
+		{"nulldata", "Embed data in an OP_RETURN txout", &nullDataCmd{}},
+		{"pubkeyhash", "Spend into pay to pubkey hash txouts", &pubKeyHashCmd{}},
+		{"sendtoaddr", "Pay an address", &sendToAddrCmd{}},
+		{"sighashsingle", "Build a SIGHASH_SINGLE signed tx", &sigHashSingleCmd{}},
+		{"bulletin", "Publish an ahimsa bulletin", &bulletinCmd{}},
+	}
+	for _, c := range commands {
+		if _, err := parser.AddCommand(c.name, c.desc, c.desc, c.cmd); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+
+	if _, err := parser.Parse(); err != nil {
+		// flags.Default already printed parse errors and help
+		if _, ok := err.(*flags.Error); !ok {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,223 @@
+package btcbuilder
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+)
+
+// The most inputs any selector will put into a single tx.
+const maxIns = 50
+
+// A CoinSelector picks the unspents that fund a tx needing target satoshis.
+// Given the same unspents every selector makes the same choice.
+type CoinSelector interface {
+	Select(unspents []*TxInParams, target int64) ([]*TxInParams, error)
+}
+
+// ErrNoExactMatch is returned by a BranchAndBound selector without a fallback
+// when no set of unspents lands within its window.
+var ErrNoExactMatch = errors.New("No set of unspents matches the target without change")
+
+func insufficientErr(target, total int64) error {
+	return fmt.Errorf("Do not have enough coins to compose input: %d, from %d", target, total)
+}
+
+// byValue orders unspents by value and then by outpoint so that selectors
+// which walk the set always walk it the same way.
+type byValue []*TxInParams
+
+func (u byValue) Len() int      { return len(u) }
+func (u byValue) Swap(i, j int) { u[i], u[j] = u[j], u[i] }
+func (u byValue) Less(i, j int) bool {
+	if u[i].TxOut.Value != u[j].TxOut.Value {
+		return u[i].TxOut.Value < u[j].TxOut.Value
+	}
+	a, b := u[i].OutPoint, u[j].OutPoint
+	if c := bytes.Compare(a.Hash[:], b.Hash[:]); c != 0 {
+		return c < 0
+	}
+	return a.Index < b.Index
+}
+
+// sortedUnspents returns a sorted copy of unspents, largest first when desc is set.
+func sortedUnspents(unspents []*TxInParams, desc bool) []*TxInParams {
+	sorted := make([]*TxInParams, len(unspents))
+	copy(sorted, unspents)
+	if desc {
+		sort.Sort(sort.Reverse(byValue(sorted)))
+	} else {
+		sort.Sort(byValue(sorted))
+	}
+	return sorted
+}
+
+// accumulate takes unspents in order until target is reached.
+func accumulate(ordered []*TxInParams, target int64) ([]*TxInParams, error) {
+	total := int64(0)
+	selected := make([]*TxInParams, 0)
+	for _, utxo := range ordered {
+		if len(selected) >= maxIns {
+			break
+		}
+		selected = append(selected, utxo)
+		total += utxo.TxOut.Value
+		if total >= target {
+			return selected, nil
+		}
+	}
+	return nil, insufficientErr(target, total)
+}
+
+// LargestFirst spends the biggest unspents first, keeping the number of
+// inputs, and so the fee, as low as possible.
+type LargestFirst struct{}
+
+func (s *LargestFirst) Select(unspents []*TxInParams, target int64) ([]*TxInParams, error) {
+	return accumulate(sortedUnspents(unspents, true), target)
+}
+
+// SmallestFirst spends the smallest unspents first which consolidates a
+// wallet full of dust at the cost of larger transactions.
+type SmallestFirst struct{}
+
+func (s *SmallestFirst) Select(unspents []*TxInParams, target int64) ([]*TxInParams, error) {
+	return accumulate(sortedUnspents(unspents, false), target)
+}
+
+// BranchAndBound searches for a set of unspents whose value falls between
+// target and target+CostOfChange so that the tx needs no change output.
+// When there is no such set the Fallback selector is used if there is one.
+type BranchAndBound struct {
+	CostOfChange int64
+	MaxTries     int // Bounds the search, zero means 100000 tries
+	Fallback     CoinSelector
+}
+
+func (s *BranchAndBound) Select(unspents []*TxInParams, target int64) ([]*TxInParams, error) {
+	sorted := sortedUnspents(unspents, true)
+
+	// remaining[i] is the value held by sorted[i:]
+	remaining := make([]int64, len(sorted)+1)
+	for i := len(sorted) - 1; i >= 0; i-- {
+		remaining[i] = remaining[i+1] + sorted[i].TxOut.Value
+	}
+
+	maxTries := s.MaxTries
+	if maxTries == 0 {
+		maxTries = 100000
+	}
+
+	tries := 0
+	chosen := make([]bool, len(sorted))
+	var best []bool
+	bestExcess := int64(-1)
+
+	var search func(i, numIns int, sum int64) bool
+	search = func(i, numIns int, sum int64) bool {
+		if sum > target+s.CostOfChange {
+			return false
+		}
+		if sum >= target {
+			if excess := sum - target; best == nil || excess < bestExcess {
+				best = make([]bool, len(chosen))
+				copy(best, chosen)
+				bestExcess = excess
+			}
+			// Nothing beats an exact match
+			return bestExcess == 0
+		}
+		if i == len(sorted) || numIns == maxIns || sum+remaining[i] < target {
+			return false
+		}
+		tries++
+		if tries > maxTries {
+			return true
+		}
+
+		chosen[i] = true
+		if search(i+1, numIns+1, sum+sorted[i].TxOut.Value) {
+			return true
+		}
+		chosen[i] = false
+		return search(i+1, numIns, sum)
+	}
+	search(0, 0, 0)
+
+	if best == nil {
+		if s.Fallback != nil {
+			return s.Fallback.Select(unspents, target)
+		}
+		return nil, ErrNoExactMatch
+	}
+
+	selected := make([]*TxInParams, 0)
+	for i, in := range best {
+		if in {
+			selected = append(selected, sorted[i])
+		}
+	}
+	return selected, nil
+}
+
+// RandomImprove picks unspents at random until the target is covered, then
+// keeps adding random unspents while doing so brings the total closer to
+// twice the target. The resulting change is about the size of the payment
+// which keeps the wallet's unspents useful for later transactions. The choice
+// is drawn from Seed so the same wallet always yields the same selection.
+type RandomImprove struct {
+	Seed int64
+}
+
+func (s *RandomImprove) Select(unspents []*TxInParams, target int64) ([]*TxInParams, error) {
+	rnd := rand.New(rand.NewSource(s.Seed))
+	sorted := sortedUnspents(unspents, false)
+	pool := make([]*TxInParams, len(sorted))
+	for i, j := range rnd.Perm(len(sorted)) {
+		pool[i] = sorted[j]
+	}
+
+	total := int64(0)
+	selected := make([]*TxInParams, 0)
+	for len(pool) > 0 && total < target && len(selected) < maxIns {
+		selected = append(selected, pool[0])
+		total += pool[0].TxOut.Value
+		pool = pool[1:]
+	}
+	if total < target {
+		return nil, insufficientErr(target, total)
+	}
+
+	ideal := 2 * target
+	upper := 3 * target
+	for _, utxo := range pool {
+		if len(selected) >= maxIns {
+			break
+		}
+		improved := total + utxo.TxOut.Value
+		if improved > upper || abs64(ideal-improved) >= abs64(ideal-total) {
+			continue
+		}
+		selected = append(selected, utxo)
+		total = improved
+	}
+	return selected, nil
+}
+
+func abs64(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// selector returns the CoinSelector the params were configured with, falling
+// back on LargestFirst.
+func (params BuilderParams) selector() CoinSelector {
+	if params.CoinSelector == nil {
+		return &LargestFirst{}
+	}
+	return params.CoinSelector
+}
@@ -0,0 +1,91 @@
+package btcbuilder
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/conformal/btcwire"
+)
+
+// selectorUnspents is a wallet of unspents that all differ in value, given
+// out of order so the selectors have to sort them.
+func selectorUnspents() []*TxInParams {
+	values := []int64{5000, 1000, 50000, 2000, 10000}
+	unspents := make([]*TxInParams, len(values))
+	for i, value := range values {
+		unspents[i] = &TxInParams{
+			TxOut:    &btcwire.TxOut{Value: value},
+			OutPoint: &btcwire.OutPoint{Index: uint32(i)},
+		}
+	}
+	return unspents
+}
+
+var selectorTests = []struct {
+	name     string
+	selector CoinSelector
+	target   int64
+	picks    []int64 // Values of the unspents picked, in the order returned
+	err      error
+}{
+	{"largest one", &LargestFirst{}, 12000, []int64{50000}, nil},
+	{"largest two", &LargestFirst{}, 60000, []int64{50000, 10000}, nil},
+	{"largest short", &LargestFirst{}, 70000, nil, insufficientErr(70000, 68000)},
+
+	{"smallest two", &SmallestFirst{}, 2500, []int64{1000, 2000}, nil},
+	{"smallest four", &SmallestFirst{}, 8500, []int64{1000, 2000, 5000, 10000}, nil},
+	{"smallest short", &SmallestFirst{}, 70000, nil, insufficientErr(70000, 68000)},
+
+	{"bnb exact", &BranchAndBound{}, 7000, []int64{5000, 2000}, nil},
+	{"bnb three", &BranchAndBound{CostOfChange: 500}, 16000, []int64{10000, 5000, 1000}, nil},
+	{"bnb window", &BranchAndBound{CostOfChange: 600}, 4500, []int64{5000}, nil},
+	{"bnb no match", &BranchAndBound{}, 4500, nil, ErrNoExactMatch},
+	{"bnb fallback", &BranchAndBound{Fallback: &LargestFirst{}}, 4500, []int64{50000}, nil},
+	{"bnb max tries", &BranchAndBound{MaxTries: 1}, 7000, nil, ErrNoExactMatch},
+	{"bnb max tries fallback", &BranchAndBound{MaxTries: 1, Fallback: &SmallestFirst{}}, 7000, []int64{1000, 2000, 5000}, nil},
+	{"bnb short", &BranchAndBound{}, 70000, nil, ErrNoExactMatch},
+	{"bnb short fallback", &BranchAndBound{Fallback: &LargestFirst{}}, 70000, nil, insufficientErr(70000, 68000)},
+
+	{"random", &RandomImprove{Seed: 1}, 3000, []int64{1000, 50000}, nil},
+	{"random improved", &RandomImprove{Seed: 7}, 3000, []int64{5000, 1000}, nil}, // 1000 brings 5000 to twice the target
+	{"random short", &RandomImprove{}, 70000, nil, insufficientErr(70000, 68000)},
+}
+
+func TestCoinSelectors(t *testing.T) {
+	for _, test := range selectorTests {
+		selected, err := test.selector.Select(selectorUnspents(), test.target)
+		if test.err != nil {
+			if err == nil || err.Error() != test.err.Error() {
+				t.Errorf("%s: got error %v, expected %v", test.name, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+
+		picks := make([]int64, len(selected))
+		for i, utxo := range selected {
+			picks[i] = utxo.TxOut.Value
+		}
+		if !reflect.DeepEqual(picks, test.picks) {
+			t.Errorf("%s: picked %v, expected %v", test.name, picks, test.picks)
+		}
+	}
+}
+
+func TestCoinSelectorsIgnoreOrder(t *testing.T) {
+	unspents := selectorUnspents()
+	reversed := make([]*TxInParams, len(unspents))
+	for i, utxo := range unspents {
+		reversed[len(unspents)-1-i] = utxo
+	}
+	for _, test := range selectorTests {
+		a, errA := test.selector.Select(unspents, test.target)
+		b, errB := test.selector.Select(reversed, test.target)
+		if (errA == nil) != (errB == nil) || !reflect.DeepEqual(a, b) {
+			t.Errorf("%s: picks depend on the order of the unspents", test.name)
+		}
+	}
+}
@@ -10,13 +10,13 @@ import (
 func TestRpc(t *testing.T) {
 	log.Println("Testing to see if rpc config works for this node!")
 
-	connCfg, _, err := CfgFromFile()
+	cfg, err := CfgFromFile()
 	if err != nil {
 		log.Println(err)
-		t.Fail()
+		t.FailNow()
 	}
 
-	client, err := makeRpcClient(connCfg)
+	client, err := makeRpcClient(cfg.ConnCfg)
 	if err != nil {
 		log.Println(err)
 		t.Fail()
@@ -30,9 +30,13 @@ func TestRpc(t *testing.T) {
 
 func TestBalance(t *testing.T) {
 	log.Println("Testing to see if wallet has adequate balance")
-	client, _ := ConfigureApp()
+	params, err := NewParams()
+	if err != nil {
+		log.Println(err)
+		t.FailNow()
+	}
 
-	bal, err := client.GetBalance("")
+	bal, err := params.Client.GetBalance("")
 	if err != nil {
 		log.Println(err)
 		t.Fail()
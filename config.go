@@ -0,0 +1,311 @@
+package btcbuilder
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/conformal/btcnet"
+	"github.com/conformal/btcrpcclient"
+	"github.com/conformal/btcutil"
+	"github.com/conformal/btcwire"
+	"github.com/jessevdk/go-flags"
+)
+
+// Environment variables that override what the conf file says.
+const (
+	EnvConf    = "BTCBUILDER_CONF"    // Path of the conf file
+	EnvNet     = "BTCBUILDER_NET"     // A name NetParamsFromStr understands
+	EnvRPCHost = "BTCBUILDER_RPCHOST" // host or host:port of the node
+	EnvRPCUser = "BTCBUILDER_RPCUSER"
+	EnvRPCPass = "BTCBUILDER_RPCPASS"
+	EnvRPCCert = "BTCBUILDER_RPCCERT" // Cert file, turns on TLS
+)
+
+// The rpc ports bitcoind listens on by default for each network.
+var defaultRPCPorts = map[string]string{
+	btcnet.MainNetParams.Name:       "8332",
+	btcnet.TestNet3Params.Name:      "18332",
+	btcnet.RegressionNetParams.Name: "18443",
+	btcnet.SimNetParams.Name:        "18556",
+}
+
+// BitcoinConf holds the settings btcbuilder reads from a bitcoin.conf like
+// ini file. Options it does not know are ignored.
+type BitcoinConf struct {
+	RPCPassword string `long:"rpcpassword"`
+	RPCUser     string `long:"rpcuser"`
+	RPCConnect  string `long:"rpcconnect"`
+	RPCPort     string `long:"rpcport"`
+	RPCCookie   string `long:"rpccookiefile"`
+	RPCCert     string `long:"rpccert"` // btcd's TLS cert, enables TLS
+	DataDir     string `long:"datadir"`
+	Testnet     bool   `long:"testnet"`
+	Regtest     bool   `long:"regtest"`
+	SimNet      bool   `long:"simnet"`
+	RPCListen   string `long:"server"` // Unset means bitcoind's default of on
+}
+
+// NodeConfig is everything needed to reach a node and know its network.
+type NodeConfig struct {
+	ConnCfg   *btcrpcclient.ConnConfig
+	NetParams *btcnet.Params // What the conf selects, NewParams asks the node
+	ConfPath  string         // The file read, empty if there was none
+}
+
+// knownNets are the networks NetFromGenesis can tell apart.
+var knownNets = []*btcnet.Params{
+	&btcnet.MainNetParams,
+	&btcnet.TestNet3Params,
+	&btcnet.RegressionNetParams,
+	&btcnet.SimNetParams,
+}
+
+// NetFromGenesis returns the network whose genesis block hashes to hash.
+func NetFromGenesis(hash *btcwire.ShaHash) (*btcnet.Params, error) {
+	for _, net := range knownNets {
+		if net.GenesisHash != nil && hash.IsEqual(net.GenesisHash) {
+			return net, nil
+		}
+	}
+	return nil, fmt.Errorf("Genesis block %s is not on a known network", hash)
+}
+
+// DetectNet asks the node behind client which network it is on.
+func DetectNet(client *btcrpcclient.Client) (*btcnet.Params, error) {
+	hash, err := client.GetBlockHash(0)
+	if err != nil {
+		return nil, err
+	}
+	return NetFromGenesis(hash)
+}
+
+// DefaultConfPath is where bitcoind keeps its conf file.
+func DefaultConfPath() string {
+	return filepath.Join(btcutil.AppDataDir("bitcoin", false), "bitcoin.conf")
+}
+
+// CfgFromFile loads the conf named by BTCBUILDER_CONF, or the default one.
+func CfgFromFile() (*NodeConfig, error) {
+	return LoadConfig("")
+}
+
+// LoadConfig reads the conf file at path. An empty path means the one named
+// by BTCBUILDER_CONF or, failing that, DefaultConfPath, which may be missing
+// when the node runs on defaults and cookie auth.
+//
+// Settings in the global section are read first and then overridden by the
+// section of the selected network ([main], [test], [regtest] or [simnet]),
+// which BTCBUILDER_NET picks over the conf's own choice. Environment variables
+// override both. Without an rpcuser and rpcpassword
+// the node's .cookie file is used to authenticate.
+func LoadConfig(path string) (*NodeConfig, error) {
+	explicit := path != ""
+	if !explicit {
+		path = os.Getenv(EnvConf)
+		explicit = path != ""
+	}
+	if !explicit {
+		path = DefaultConfPath()
+	}
+
+	fileconf := &BitcoinConf{}
+	raw, err := ioutil.ReadFile(path)
+	switch {
+	case err == nil:
+	case os.IsNotExist(err) && !explicit:
+		path = ""
+	default:
+		return nil, err
+	}
+
+	sections := splitSections(raw)
+	if err := parseConfSection(fileconf, sections[""]); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	netparams, err := confNet(fileconf)
+	if err != nil {
+		return nil, err
+	}
+	if name := os.Getenv(EnvNet); name != "" {
+		if netparams, err = NetParamsFromStr(name); err != nil {
+			return nil, err
+		}
+	}
+	// Like bitcoind, only main takes its rpcport from the global section
+	if netparams.Name != btcnet.MainNetParams.Name {
+		fileconf.RPCPort = ""
+	}
+	if err := parseConfSection(fileconf, sections[confSection(netparams)]); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+
+	if listen, err := strconv.ParseBool(fileconf.RPCListen); err == nil && !listen {
+		return nil, errors.New("Bitcoind not listening for rpc commands")
+	}
+	applyEnv(fileconf)
+
+	connCfg, err := fileconf.connConfig(netparams)
+	if err != nil {
+		return nil, err
+	}
+	return &NodeConfig{ConnCfg: connCfg, NetParams: netparams, ConfPath: path}, nil
+}
+
+// splitSections sorts the lines of an ini file by the section they are in.
+// Lines before any section header are under "".
+func splitSections(raw []byte) map[string][]byte {
+	sections := make(map[string][]byte)
+	name := ""
+	scanner := bufio.NewScanner(bytes.NewReader(raw))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			name = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		sections[name] = append(sections[name], line+"\n"...)
+	}
+	return sections
+}
+
+// parseConfSection reads the options in section into fileconf. Options that
+// are not in the section keep their value.
+func parseConfSection(fileconf *BitcoinConf, section []byte) error {
+	if len(section) == 0 {
+		return nil
+	}
+	parser := flags.NewParser(fileconf, flags.IgnoreUnknown)
+	return flags.NewIniParser(parser).Parse(bytes.NewReader(section))
+}
+
+// confNet picks the network the conf file selects.
+func confNet(fileconf *BitcoinConf) (*btcnet.Params, error) {
+	selected := make([]*btcnet.Params, 0, 1)
+	if fileconf.Testnet {
+		selected = append(selected, &btcnet.TestNet3Params)
+	}
+	if fileconf.Regtest {
+		selected = append(selected, &btcnet.RegressionNetParams)
+	}
+	if fileconf.SimNet {
+		selected = append(selected, &btcnet.SimNetParams)
+	}
+	switch len(selected) {
+	case 0:
+		return &btcnet.MainNetParams, nil
+	case 1:
+		return selected[0], nil
+	}
+	return nil, errors.New("Only one of testnet, regtest and simnet can be set")
+}
+
+// confSection names the section of the conf file that applies to netparams.
+func confSection(netparams *btcnet.Params) string {
+	switch netparams.Name {
+	case btcnet.MainNetParams.Name:
+		return "main"
+	case btcnet.TestNet3Params.Name:
+		return "test"
+	}
+	return netparams.Name
+}
+
+// applyEnv overrides fileconf with whatever is set in the environment.
+func applyEnv(fileconf *BitcoinConf) {
+	if hostport := os.Getenv(EnvRPCHost); hostport != "" {
+		host, port, err := net.SplitHostPort(hostport)
+		if err != nil {
+			host, port = hostport, ""
+		}
+		fileconf.RPCConnect = host
+		if port != "" {
+			fileconf.RPCPort = port
+		}
+	}
+	if user := os.Getenv(EnvRPCUser); user != "" {
+		fileconf.RPCUser = user
+	}
+	if pass := os.Getenv(EnvRPCPass); pass != "" {
+		fileconf.RPCPassword = pass
+	}
+	if cert := os.Getenv(EnvRPCCert); cert != "" {
+		fileconf.RPCCert = cert
+	}
+}
+
+// connConfig describes how to reach the node on netparams.
+func (fileconf *BitcoinConf) connConfig(netparams *btcnet.Params) (*btcrpcclient.ConnConfig, error) {
+	host := fileconf.RPCConnect
+	if host == "" {
+		host = "127.0.0.1"
+	}
+	port := fileconf.RPCPort
+	if port == "" {
+		port = defaultRPCPorts[netparams.Name]
+	}
+
+	connCfg := &btcrpcclient.ConnConfig{
+		Host:         net.JoinHostPort(host, port),
+		User:         fileconf.RPCUser,
+		Pass:         fileconf.RPCPassword,
+		HttpPostMode: true,
+		DisableTLS:   true,
+	}
+
+	if fileconf.RPCCert != "" {
+		cert, err := ioutil.ReadFile(fileconf.RPCCert)
+		if err != nil {
+			return nil, err
+		}
+		connCfg.Certificates = cert
+		connCfg.DisableTLS = false
+	}
+
+	if connCfg.User == "" && connCfg.Pass == "" {
+		user, pass, err := readCookie(fileconf.cookiePath(netparams))
+		if err != nil {
+			return nil, err
+		}
+		connCfg.User, connCfg.Pass = user, pass
+	}
+	return connCfg, nil
+}
+
+// cookiePath is where the node on netparams writes its .cookie file.
+func (fileconf *BitcoinConf) cookiePath(netparams *btcnet.Params) string {
+	if fileconf.RPCCookie != "" && filepath.IsAbs(fileconf.RPCCookie) {
+		return fileconf.RPCCookie
+	}
+	dir := fileconf.DataDir
+	if dir == "" {
+		dir = btcutil.AppDataDir("bitcoin", false)
+	}
+	if netparams.Name != btcnet.MainNetParams.Name {
+		dir = filepath.Join(dir, netparams.Name)
+	}
+	if fileconf.RPCCookie != "" {
+		return filepath.Join(dir, fileconf.RPCCookie)
+	}
+	return filepath.Join(dir, ".cookie")
+}
+
+// readCookie splits the user:password pair bitcoind writes at startup.
+func readCookie(path string) (string, string, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", "", fmt.Errorf("No rpcuser and rpcpassword set and no cookie: %s", err)
+	}
+	parts := strings.SplitN(strings.TrimSpace(string(raw)), ":", 2)
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf("%s is not a valid cookie file", path)
+	}
+	return parts[0], parts[1], nil
+}
@@ -0,0 +1,89 @@
+package btcbuilder
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/conformal/btcnet"
+)
+
+func TestLoadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "btcbuilder-conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	conf := filepath.Join(dir, "bitcoin.conf")
+	raw := "datadir=" + dir + "\nregtest=1\nrpcport=9999\n\n[main]\nrpcport=1\n\n[regtest]\nrpcconnect=10.0.0.2\n"
+	if err := ioutil.WriteFile(conf, []byte(raw), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "regtest"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	cookie := filepath.Join(dir, "regtest", ".cookie")
+	if err := ioutil.WriteFile(cookie, []byte("__cookie__:secret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := LoadConfig(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.NetParams.Name != btcnet.RegressionNetParams.Name {
+		t.Errorf("Loaded %s instead of regtest", cfg.NetParams.Name)
+	}
+	// The global rpcport only applies to main
+	if cfg.ConnCfg.Host != "10.0.0.2:18443" {
+		t.Errorf("Host is %s, the [main] or global rpcport leaked in", cfg.ConnCfg.Host)
+	}
+	if cfg.ConnCfg.User != "__cookie__" || cfg.ConnCfg.Pass != "secret" {
+		t.Errorf("Cookie auth not used: %s:%s", cfg.ConnCfg.User, cfg.ConnCfg.Pass)
+	}
+	if !cfg.ConnCfg.DisableTLS {
+		t.Error("TLS on without a cert")
+	}
+
+	os.Setenv(EnvRPCHost, "node.local:8000")
+	os.Setenv(EnvRPCUser, "alice")
+	os.Setenv(EnvRPCPass, "hunter2")
+	defer func() {
+		os.Unsetenv(EnvRPCHost)
+		os.Unsetenv(EnvRPCUser)
+		os.Unsetenv(EnvRPCPass)
+	}()
+	cfg, err = LoadConfig(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.ConnCfg.Host != "node.local:8000" || cfg.ConnCfg.User != "alice" || cfg.ConnCfg.Pass != "hunter2" {
+		t.Errorf("Environment did not override the conf: %+v", cfg.ConnCfg)
+	}
+
+	os.Unsetenv(EnvRPCHost)
+
+	// The network named in the environment picks the section
+	raw = "datadir=" + dir + "\ntestnet=1\n\n[test]\nrpcport=7777\n\n[regtest]\nrpcport=8888\n"
+	if err := ioutil.WriteFile(conf, []byte(raw), 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv(EnvNet, "TestNet")
+	defer os.Unsetenv(EnvNet)
+	cfg, err = LoadConfig(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.NetParams.Name != btcnet.RegressionNetParams.Name {
+		t.Errorf("Loaded %s instead of regtest", cfg.NetParams.Name)
+	}
+	if cfg.ConnCfg.Host != "127.0.0.1:8888" {
+		t.Errorf("Host is %s, expected the [regtest] rpcport", cfg.ConnCfg.Host)
+	}
+
+	if _, err := LoadConfig(filepath.Join(dir, "missing.conf")); err == nil {
+		t.Error("A missing explicit conf file loaded")
+	}
+}
@@ -0,0 +1,89 @@
+package btcbuilder
+
+import (
+	"fmt"
+
+	"github.com/conformal/btcjson"
+	"github.com/conformal/btcwire"
+)
+
+// Error codes bitcoind answers with that the library cares about.
+const (
+	rpcMethodNotFound = -32601
+	rpcVerifyRejected = -26
+	rpcVerifyInChain  = -27
+)
+
+// BuildError is returned by Send when the builder could not produce a tx.
+type BuildError struct {
+	Err error
+}
+
+func (e *BuildError) Error() string {
+	return fmt.Sprintf("Building tx failed: %s", e.Err)
+}
+
+// RejectedError is returned when the node refuses a tx because it breaks
+// consensus or policy rules.
+type RejectedError struct {
+	Hash   *btcwire.ShaHash
+	Code   int
+	Reason string
+}
+
+func (e *RejectedError) Error() string {
+	return fmt.Sprintf("Node rejected tx %s (%d): %s", e.Hash, e.Code, e.Reason)
+}
+
+// AlreadyInChainError is returned when the node already has the tx, either
+// in a block or, if InMempool is set, waiting in its mempool. Callers that
+// only care that the tx made it out can treat this as success.
+type AlreadyInChainError struct {
+	Hash      *btcwire.ShaHash
+	InMempool bool
+}
+
+func (e *AlreadyInChainError) Error() string {
+	if e.InMempool {
+		return fmt.Sprintf("Tx %s is already in the mempool", e.Hash)
+	}
+	return fmt.Sprintf("Tx %s is already in the chain", e.Hash)
+}
+
+// TransportError covers failures talking to the node at all, when no answer
+// came back. The tx may or may not have reached it.
+type TransportError struct {
+	Err error
+}
+
+func (e *TransportError) Error() string {
+	return fmt.Sprintf("Rpc to node failed: %s", e.Err)
+}
+
+// jsonError pulls the code and message out of an error the node reported.
+func jsonError(err error) (*btcjson.Error, bool) {
+	switch e := err.(type) {
+	case *btcjson.Error:
+		return e, true
+	case btcjson.Error:
+		return &e, true
+	}
+	return nil, false
+}
+
+// sendError sorts an error from sendrawtransaction into one of the types above.
+// Any error the node answers with that is not about the tx already being known
+// means it did not take the tx.
+func sendError(err error, hash *btcwire.ShaHash) error {
+	jsonErr, ok := jsonError(err)
+	if !ok {
+		return &TransportError{Err: err}
+	}
+	switch {
+	case jsonErr.Code == rpcVerifyInChain:
+		return &AlreadyInChainError{Hash: hash}
+	case jsonErr.Code == rpcVerifyRejected && jsonErr.Message == "txn-already-in-mempool":
+		return &AlreadyInChainError{Hash: hash, InMempool: true}
+	}
+	return &RejectedError{Hash: hash, Code: jsonErr.Code, Reason: jsonErr.Message}
+}
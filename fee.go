@@ -0,0 +1,93 @@
+package btcbuilder
+
+import (
+	"github.com/conformal/btcscript"
+	"github.com/conformal/btcwire"
+)
+
+// Sizes in bytes of the pieces of a serialized tx that do not depend on the
+// scripts inside of it.
+const (
+	txOverhead   = 4 + 4 // version and locktime
+	txInOverhead = 32 + 4 + 4
+	sigSize      = 1 + 72 + 1 // push, DER encoding at its largest, sighash flag
+	// A pay to pubkey hash script does not say whether its key is compressed
+	// so inputs are priced as if it were not.
+	pubKeySize = 1 + uncompressedKeySize
+)
+
+// The number of times a builder will re-select inputs while waiting for the fee
+// to settle.
+const maxFeeRounds = 10
+
+// sigScriptSize predicts how large the signature script that spends pkScript
+// will be. Scripts that cannot be picked apart are assumed to be pay to pubkey
+// hash, since that is what a wallet almost always hands out.
+func sigScriptSize(pkScript []byte) int {
+	switch btcscript.GetScriptClass(pkScript) {
+	case btcscript.PubKeyTy:
+		return sigSize
+	case btcscript.MultiSigTy:
+		// The script opens with OP_M
+		nRequired := int(pkScript[0]) - (btcscript.OP_1 - 1)
+		// OP_0 <sig> ... <sig>
+		return 1 + nRequired*sigSize
+	}
+	return sigSize + pubKeySize
+}
+
+// estimateSize computes the serialized size of a tx that spends prevScripts
+// and pays out to txouts once every input has been signed. A nil entry in
+// prevScripts is treated as a pay to pubkey hash input.
+func estimateSize(prevScripts [][]byte, txouts []*btcwire.TxOut) int {
+	size := txOverhead + varIntSize(len(prevScripts)) + varIntSize(len(txouts))
+	for _, pkScript := range prevScripts {
+		scriptLen := sigScriptSize(pkScript)
+		size += txInOverhead + varIntSize(scriptLen) + scriptLen
+	}
+	for _, txout := range txouts {
+		size += 8 + varIntSize(len(txout.PkScript)) + len(txout.PkScript)
+	}
+	return size
+}
+
+// varIntSize is the number of bytes needed to encode n as a bitcoin varint.
+func varIntSize(n int) int {
+	switch {
+	case n < 0xfd:
+		return 1
+	case n <= 0xffff:
+		return 3
+	case n <= 0xffffffff:
+		return 5
+	}
+	return 9
+}
+
+// p2pkhIns stands in for n pay to pubkey hash inputs in estimateSize.
+func p2pkhIns(n int64) [][]byte {
+	return make([][]byte, n)
+}
+
+// p2pkhOuts returns n placeholder pay to pubkey hash txouts for estimateSize.
+func p2pkhOuts(n int64) []*btcwire.TxOut {
+	txouts := make([]*btcwire.TxOut, n)
+	for i := range txouts {
+		txouts[i] = btcwire.NewTxOut(0, make([]byte, 25))
+	}
+	return txouts
+}
+
+// inScripts collects the pkScripts an inParamSet spends for estimateSize.
+func inScripts(inParamSet []*TxInParams) [][]byte {
+	scripts := make([][]byte, len(inParamSet))
+	for i, inpParam := range inParamSet {
+		scripts[i] = inpParam.TxOut.PkScript
+	}
+	return scripts
+}
+
+// fee is what a tx of size bytes pays at the params' current rate.
+func (params BuilderParams) fee(size int) int64 {
+	return params.feeRate() * int64(size)
+}
@@ -0,0 +1,71 @@
+package btcbuilder
+
+import (
+	"testing"
+
+	"github.com/conformal/btcec"
+	"github.com/conformal/btcnet"
+	"github.com/conformal/btcscript"
+	"github.com/conformal/btcutil"
+	"github.com/conformal/btcwire"
+)
+
+// A DER signature comes out one or two bytes shorter than sigSize allows for
+// about half the time.
+const sigSlack = 3
+
+func TestEstimateSize(t *testing.T) {
+	net := &btcnet.TestNet3Params
+	ks := NewKeyStore(net)
+
+	priv, _ := btcec.NewPrivateKey(btcec.S256())
+	uncompressed, _ := btcutil.NewWIF(priv, net, false)
+	compressed := newWifKeyPair(net)
+	keys := []*btcutil.WIF{compressed, uncompressed, newWifKeyPair(net), newWifKeyPair(net)}
+	pubkeys := make([]*btcutil.AddressPubKey, len(keys))
+	for i, wif := range keys {
+		if _, err := ks.AddKey(wif); err != nil {
+			t.Fatal(err)
+		}
+		pubkeys[i], _ = btcutil.NewAddressPubKey(wif.SerializePubKey(), net)
+	}
+
+	pkhScript := func(wif *btcutil.WIF) []byte {
+		addr, _ := wifToAddr(wif, net)
+		script, _ := btcscript.PayToAddrScript(addr)
+		return script
+	}
+	multiSig, _ := btcscript.MultiSigScript([]*btcutil.AddressPubKey{pubkeys[0], pubkeys[2], pubkeys[3]}, 2)
+	pubKey, _ := btcscript.PayToAddrScript(pubkeys[0])
+
+	tests := []struct {
+		name     string
+		pkScript []byte
+		slack    int // How much the estimate may exceed the real size
+	}{
+		{"pubkeyhash compressed", pkhScript(compressed), sigSlack + uncompressedKeySize - compressedKeySize},
+		{"pubkeyhash uncompressed", pkhScript(uncompressed), sigSlack},
+		{"multisig 2 of 3", multiSig, 2 * sigSlack},
+		{"pubkey", pubKey, sigSlack},
+	}
+	for _, test := range tests {
+		msgtx := btcwire.NewMsgTx()
+		msgtx.AddTxIn(btcwire.NewTxIn(btcwire.NewOutPoint(&btcwire.ShaHash{}, 0), nil))
+		msgtx.AddTxOut(btcwire.NewTxOut(1e5, pkhScript(compressed)))
+
+		sigScript, err := ks.Sign(msgtx, 0, test.pkScript, btcscript.SigHashAll)
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		msgtx.TxIn[0].SignatureScript = sigScript
+
+		actual := msgtx.SerializeSize()
+		estimate := estimateSize([][]byte{test.pkScript}, msgtx.TxOut)
+		if estimate < actual {
+			t.Errorf("%s: estimated %d bytes for a %d byte tx, it would underpay", test.name, estimate, actual)
+		}
+		if estimate > actual+test.slack {
+			t.Errorf("%s: estimated %d bytes for a %d byte tx", test.name, estimate, actual)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package btcbuilder
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/conformal/btcrpcclient"
+	"github.com/conformal/btcutil"
+)
+
+// A FeeEstimator suggests the rate in satoshis per byte a tx must pay to
+// confirm within numBlocks blocks.
+type FeeEstimator interface {
+	FeeRate(numBlocks int) (int64, error)
+}
+
+// DefaultFeeTable is a conservative set of rates for when nothing better is known.
+var DefaultFeeTable = map[int]int64{
+	1:  60,
+	3:  40,
+	6:  20,
+	25: 10,
+}
+
+// StaticFeeEstimator answers from a fixed table of confirmation targets to
+// rates so that builders can price transactions offline.
+type StaticFeeEstimator struct {
+	Table map[int]int64
+}
+
+func NewStaticFeeEstimator(table map[int]int64) *StaticFeeEstimator {
+	return &StaticFeeEstimator{Table: table}
+}
+
+// LoadFeeTable reads a json object mapping confirmation targets to rates,
+// e.g. {"1": 60, "6": 20}, and returns an estimator for it.
+func LoadFeeTable(path string) (*StaticFeeEstimator, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	strTable := make(map[string]int64)
+	if err := json.Unmarshal(raw, &strTable); err != nil {
+		return nil, err
+	}
+
+	table := make(map[int]int64)
+	for target, rate := range strTable {
+		numBlocks, err := strconv.Atoi(target)
+		if err != nil {
+			return nil, err
+		}
+		table[numBlocks] = rate
+	}
+	return NewStaticFeeEstimator(table), nil
+}
+
+// FeeRate uses the entry with the largest target that is still within
+// numBlocks. Targets tighter than anything in the table get the fastest rate.
+func (est *StaticFeeEstimator) FeeRate(numBlocks int) (int64, error) {
+	if len(est.Table) < 1 {
+		return 0, errors.New("Fee table is empty")
+	}
+	targets := make([]int, 0, len(est.Table))
+	for target := range est.Table {
+		targets = append(targets, target)
+	}
+	sort.Ints(targets)
+
+	rate := est.Table[targets[0]]
+	for _, target := range targets {
+		if target > numBlocks {
+			break
+		}
+		rate = est.Table[target]
+	}
+	return rate, nil
+}
+
+// How long an RPCFeeEstimator trusts the last answer from the node.
+const feeCacheTime = time.Minute
+
+// RPCFeeEstimator asks the node with estimatesmartfee, or estimatefee on
+// nodes that predate it. When the node has no estimate the Fallback is used.
+type RPCFeeEstimator struct {
+	ConnCfg  *btcrpcclient.ConnConfig
+	Fallback FeeEstimator
+
+	mtx    sync.Mutex
+	cached map[int]cachedRate
+}
+
+type cachedRate struct {
+	rate    int64
+	fetched time.Time
+}
+
+func NewRPCFeeEstimator(connCfg *btcrpcclient.ConnConfig, fallback FeeEstimator) *RPCFeeEstimator {
+	est := RPCFeeEstimator{
+		ConnCfg:  connCfg,
+		Fallback: fallback,
+		cached:   make(map[int]cachedRate),
+	}
+	return &est
+}
+
+func (est *RPCFeeEstimator) FeeRate(numBlocks int) (int64, error) {
+	est.mtx.Lock()
+	defer est.mtx.Unlock()
+
+	if c, ok := est.cached[numBlocks]; ok && time.Since(c.fetched) < feeCacheTime {
+		return c.rate, nil
+	}
+
+	btcPerKb, err := est.nodeEstimate(numBlocks)
+	if err != nil || btcPerKb <= 0 {
+		if est.Fallback == nil {
+			if err == nil {
+				err = errors.New("Node has no fee estimate")
+			}
+			return 0, err
+		}
+		return est.Fallback.FeeRate(numBlocks)
+	}
+
+	// Nodes answer in BTC per kilobyte
+	rate := int64(math.Ceil(btcPerKb * btcutil.SatoshiPerBitcoin / 1000))
+	est.cached[numBlocks] = cachedRate{rate: rate, fetched: time.Now()}
+	return rate, nil
+}
+
+// nodeEstimate returns the node's rate in BTC/kB. A result of zero or less
+// means the node does not have enough data to say.
+func (est *RPCFeeEstimator) nodeEstimate(numBlocks int) (float64, error) {
+	raw, err := rpcRequest(est.ConnCfg, "estimatesmartfee", numBlocks)
+	if err == nil {
+		var smart struct {
+			FeeRate float64 `json:"feerate"`
+		}
+		if err := json.Unmarshal(raw, &smart); err != nil {
+			return 0, err
+		}
+		return smart.FeeRate, nil
+	}
+
+	// Older nodes only know estimatefee
+	if jsonErr, ok := jsonError(err); !ok || jsonErr.Code != rpcMethodNotFound {
+		return 0, err
+	}
+	raw, err = rpcRequest(est.ConnCfg, "estimatefee", numBlocks)
+	if err != nil {
+		return 0, err
+	}
+	var btcPerKb float64
+	if err := json.Unmarshal(raw, &btcPerKb); err != nil {
+		return 0, err
+	}
+	return btcPerKb, nil
+}
+
+// How long a FeeSnapshot holds on to a rate.
+const DefaultFeeSnapshotTTL = 10 * time.Minute
+
+// FeeSnapshot remembers the answer Estimator gives for each target for TTL,
+// so that every builder sharing params prices its txs at the same rate. A
+// FanOut sized for its children at one rate would otherwise leave them short
+// when the estimate rises before they are built. Failures are not remembered.
+type FeeSnapshot struct {
+	Estimator FeeEstimator
+	TTL       time.Duration
+
+	mtx   sync.Mutex
+	rates map[int]cachedRate
+}
+
+func NewFeeSnapshot(est FeeEstimator) *FeeSnapshot {
+	return &FeeSnapshot{
+		Estimator: est,
+		TTL:       DefaultFeeSnapshotTTL,
+		rates:     make(map[int]cachedRate),
+	}
+}
+
+// FeeRate returns the rate taken for numBlocks within the last TTL, asking
+// Estimator when there is none.
+func (snap *FeeSnapshot) FeeRate(numBlocks int) (int64, error) {
+	snap.mtx.Lock()
+	defer snap.mtx.Unlock()
+
+	if r, ok := snap.rates[numBlocks]; ok && time.Since(r.fetched) < snap.TTL {
+		return r.rate, nil
+	}
+	rate, err := snap.Estimator.FeeRate(numBlocks)
+	if err != nil {
+		return 0, err
+	}
+	snap.rates[numBlocks] = cachedRate{rate: rate, fetched: time.Now()}
+	return rate, nil
+}
+
+// Refresh forgets the rates taken so builders made afterwards get new ones.
+func (snap *FeeSnapshot) Refresh() {
+	snap.mtx.Lock()
+	snap.rates = make(map[int]cachedRate)
+	snap.mtx.Unlock()
+}
+
+// feeRate is the rate builders pay. An estimator, when present, takes
+// precedence over the fixed FeeRate which remains the fallback if the
+// estimator fails.
+func (params BuilderParams) feeRate() int64 {
+	if params.FeeEstimator == nil {
+		return params.FeeRate
+	}
+	rate, err := params.FeeEstimator.FeeRate(params.ConfTarget)
+	if err != nil {
+		if params.Logger != nil {
+			params.Logger.Printf("Fee estimation failed: %s\n", err)
+		}
+		return params.FeeRate
+	}
+	return rate
+}
@@ -0,0 +1,149 @@
+package btcbuilder
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/NSkelsey/btcbuilder/mockrpc"
+	"github.com/conformal/btcnet"
+)
+
+func TestStaticFeeEstimator(t *testing.T) {
+	est := NewStaticFeeEstimator(map[int]int64{2: 50, 6: 20, 25: 5})
+	tests := []struct {
+		numBlocks int
+		rate      int64
+	}{
+		{1, 50}, // Tighter than the table takes the fastest rate
+		{2, 50},
+		{5, 50},
+		{6, 20},
+		{24, 20},
+		{100, 5},
+	}
+	for _, test := range tests {
+		rate, err := est.FeeRate(test.numBlocks)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rate != test.rate {
+			t.Errorf("FeeRate(%d) = %d, expected %d", test.numBlocks, rate, test.rate)
+		}
+	}
+
+	if _, err := NewStaticFeeEstimator(nil).FeeRate(6); err == nil {
+		t.Errorf("An empty table gave a rate")
+	}
+}
+
+func TestLoadFeeTable(t *testing.T) {
+	dir, err := ioutil.TempDir("", "btcbuilder-fees")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "fees.json")
+	if err := ioutil.WriteFile(path, []byte(`{"1": 60, "6": 20}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	est, err := LoadFeeTable(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(est.Table) != 2 || est.Table[1] != 60 || est.Table[6] != 20 {
+		t.Errorf("Loaded %v", est.Table)
+	}
+
+	bad := filepath.Join(dir, "bad.json")
+	if err := ioutil.WriteFile(bad, []byte(`{"soon": 60}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadFeeTable(bad); err == nil {
+		t.Errorf("Loaded a table with a target that is not a number")
+	}
+	if _, err := LoadFeeTable(filepath.Join(dir, "missing.json")); err == nil {
+		t.Errorf("Loaded a table that does not exist")
+	}
+}
+
+func TestRPCFeeEstimatorFallback(t *testing.T) {
+	net := btcnet.TestNet3Params
+	srv := mockrpc.NewServer(&net)
+	defer srv.Close()
+
+	// The mock knows neither estimatesmartfee nor estimatefee
+	est := NewRPCFeeEstimator(srv.ConnConfig(), NewStaticFeeEstimator(DefaultFeeTable))
+	rate, err := est.FeeRate(6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rate != DefaultFeeTable[6] {
+		t.Errorf("Fell back to %d, expected %d", rate, DefaultFeeTable[6])
+	}
+
+	est.Fallback = nil
+	if _, err := est.FeeRate(6); err == nil {
+		t.Errorf("No estimate and no fallback still gave a rate")
+	}
+}
+
+// risingEstimator quotes a higher rate every time it is asked, after failing
+// the first fails times.
+type risingEstimator struct {
+	rate  int64
+	fails int
+}
+
+func (est *risingEstimator) FeeRate(numBlocks int) (int64, error) {
+	if est.fails > 0 {
+		est.fails--
+		return 0, errors.New("Estimator unavailable")
+	}
+	est.rate += 10
+	return est.rate, nil
+}
+
+func TestFeeSnapshot(t *testing.T) {
+	est := &risingEstimator{fails: 1}
+	snap := NewFeeSnapshot(est)
+	params := BuilderParams{
+		FeeRate:      5,
+		FeeEstimator: snap,
+		ConfTarget:   6,
+		DustAmnt:     546,
+		Logger:       log.New(ioutil.Discard, "", 0),
+	}
+
+	// A failure falls back on FeeRate without sticking
+	if rate := params.feeRate(); rate != 5 {
+		t.Errorf("Rate with a failing estimator is %d, expected the fallback of 5", rate)
+	}
+
+	child := NewDustBuilder(params, 2)
+	fanout := NewFanOutBuilder(params, []TxBuilder{child}, 3)
+	need := child.SatNeeded()
+	fanout.SatNeeded()
+	if child.SatNeeded() != need {
+		t.Errorf("Child needs %d after the fanout priced itself, %d before", child.SatNeeded(), need)
+	}
+	if est.rate != 10 {
+		t.Errorf("Estimator was asked %d times, expected once", est.rate/10)
+	}
+
+	snap.Refresh()
+	if rate := params.feeRate(); rate != 20 {
+		t.Errorf("Rate after Refresh is %d, expected a new estimate of 20", rate)
+	}
+
+	snap.TTL = time.Millisecond
+	time.Sleep(2 * time.Millisecond)
+	if rate := params.feeRate(); rate != 30 {
+		t.Errorf("Rate past the TTL is %d, expected a new estimate of 30", rate)
+	}
+}
@@ -0,0 +1,549 @@
+// Package mockrpc runs an in process stand in for bitcoind's json-rpc server.
+// It implements the subset of calls btcbuilder makes against an in memory
+// wallet and mempool so that builders can be tested without a network.
+package mockrpc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	"github.com/conformal/btcec"
+	"github.com/conformal/btcjson"
+	"github.com/conformal/btcnet"
+	"github.com/conformal/btcrpcclient"
+	"github.com/conformal/btcscript"
+	"github.com/conformal/btcutil"
+	"github.com/conformal/btcwire"
+)
+
+// Error codes the server answers with. They mirror bitcoind's.
+const (
+	ErrMethodNotFound      = -32601
+	ErrInvalidParams       = -32602
+	ErrWallet              = -4
+	ErrInvalidAddressOrKey = -5
+	ErrInvalidParameter    = -8
+	ErrDeserialization     = -22
+	ErrVerify              = -25
+	ErrVerifyRejected      = -26
+	ErrVerifyInChain       = -27
+)
+
+type utxo struct {
+	txout     *btcwire.TxOut
+	confirmed bool
+}
+
+// Server is a fake bitcoind. Every exported method is safe to call while
+// clients are connected.
+type Server struct {
+	NetParams *btcnet.Params
+
+	mtx      sync.Mutex
+	keys     map[string]*btcutil.WIF // keyed by encoded address
+	txs      map[btcwire.ShaHash]*btcwire.MsgTx
+	mempool  map[btcwire.ShaHash]struct{}
+	unspent  map[btcwire.OutPoint]*utxo
+	order    []btcwire.OutPoint // The order unspents were created in
+	numFunds uint64
+	failures map[string]int            // Calls left to fail by method
+	errors   map[string]*btcjson.Error // Errors to answer the next call with by method
+
+	httpServer *httptest.Server
+}
+
+// NewServer starts a server on a local port that answers for the provided network.
+func NewServer(net *btcnet.Params) *Server {
+	s := &Server{
+		NetParams: net,
+		keys:      make(map[string]*btcutil.WIF),
+		txs:       make(map[btcwire.ShaHash]*btcwire.MsgTx),
+		mempool:   make(map[btcwire.ShaHash]struct{}),
+		unspent:   make(map[btcwire.OutPoint]*utxo),
+		order:     make([]btcwire.OutPoint, 0),
+		failures:  make(map[string]int),
+		errors:    make(map[string]*btcjson.Error),
+	}
+	s.httpServer = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// Close shuts the server down.
+func (s *Server) Close() {
+	s.httpServer.Close()
+}
+
+// ConnConfig describes how a btcrpcclient reaches the server.
+func (s *Server) ConnConfig() *btcrpcclient.ConnConfig {
+	return &btcrpcclient.ConnConfig{
+		Host:         strings.TrimPrefix(s.httpServer.URL, "http://"),
+		User:         "user",
+		Pass:         "pass",
+		HttpPostMode: true,
+		DisableTLS:   true,
+	}
+}
+
+// Fund confirms a coinbase like tx that pays amnt to a new wallet address.
+func (s *Server) Fund(amnt int64) (*btcwire.ShaHash, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	addr, err := s.newAddr()
+	if err != nil {
+		return nil, err
+	}
+	script, err := btcscript.PayToAddrScript(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	// The counter in the scriptSig keeps every funding tx unique
+	s.numFunds++
+	unique := make([]byte, 8)
+	binary.LittleEndian.PutUint64(unique, s.numFunds)
+	prevOut := btcwire.NewOutPoint(&btcwire.ShaHash{}, btcwire.MaxTxInSequenceNum)
+
+	msgtx := btcwire.NewMsgTx()
+	msgtx.AddTxIn(btcwire.NewTxIn(prevOut, unique))
+	msgtx.AddTxOut(btcwire.NewTxOut(amnt, script))
+
+	hash := btcutil.NewTx(msgtx).Sha()
+	s.txs[*hash] = msgtx
+	s.addOutputs(hash, msgtx, true)
+	return hash, nil
+}
+
+// FailNext makes the next n calls to method fail as if the node were
+// unreachable, for exercising retries.
+func (s *Server) FailNext(method string, n int) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.failures[method] = n
+}
+
+// ErrorNext makes the next call to method answer with a json-rpc error of
+// code, as the node does when it refuses a call.
+func (s *Server) ErrorNext(method string, code int, message string) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.errors[method] = &btcjson.Error{Code: code, Message: message}
+}
+
+// Mine confirms everything in the mempool.
+func (s *Server) Mine() {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	for _, u := range s.unspent {
+		u.confirmed = true
+	}
+	s.mempool = make(map[btcwire.ShaHash]struct{})
+}
+
+// Mempool returns the transactions accepted since the last call to Mine.
+func (s *Server) Mempool() []*btcwire.MsgTx {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	txs := make([]*btcwire.MsgTx, 0, len(s.mempool))
+	for hash := range s.mempool {
+		txs = append(txs, s.txs[hash])
+	}
+	return txs
+}
+
+// Balance is the value of every confirmed unspent the wallet can sign for.
+func (s *Server) Balance() int64 {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	return s.balance()
+}
+
+func (s *Server) balance() int64 {
+	total := int64(0)
+	for _, u := range s.unspent {
+		if u.confirmed && s.isMine(u.txout.PkScript) {
+			total += u.txout.Value
+		}
+	}
+	return total
+}
+
+func (s *Server) newAddr() (btcutil.Address, error) {
+	priv, err := btcec.NewPrivateKey(btcec.S256())
+	if err != nil {
+		return nil, err
+	}
+	wif, err := btcutil.NewWIF(priv, s.NetParams, true)
+	if err != nil {
+		return nil, err
+	}
+	addr, err := btcutil.NewAddressPubKeyHash(btcutil.Hash160(wif.SerializePubKey()), s.NetParams)
+	if err != nil {
+		return nil, err
+	}
+	s.keys[addr.EncodeAddress()] = wif
+	return addr, nil
+}
+
+// isMine reports whether the wallet holds the key that spends pkScript.
+func (s *Server) isMine(pkScript []byte) bool {
+	_, addrs, _, err := btcscript.ExtractPkScriptAddrs(pkScript, s.NetParams)
+	if err != nil || len(addrs) != 1 {
+		return false
+	}
+	_, ok := s.keys[addrs[0].EncodeAddress()]
+	return ok
+}
+
+func (s *Server) addOutputs(hash *btcwire.ShaHash, msgtx *btcwire.MsgTx, confirmed bool) {
+	for i, txout := range msgtx.TxOut {
+		op := *btcwire.NewOutPoint(hash, uint32(i))
+		s.unspent[op] = &utxo{txout: txout, confirmed: confirmed}
+		s.order = append(s.order, op)
+	}
+}
+
+type request struct {
+	Id     interface{}       `json:"id"`
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+}
+
+type response struct {
+	Result interface{}    `json:"result"`
+	Error  *btcjson.Error `json:"error"`
+	Id     interface{}    `json:"id"`
+}
+
+type handler func(s *Server, params []json.RawMessage) (interface{}, *btcjson.Error)
+
+var handlers = map[string]handler{
+	"getdifficulty":      handleGetDifficulty,
+	"getblockhash":       handleGetBlockHash,
+	"listunspent":        handleListUnspent,
+	"dumpprivkey":        handleDumpPrivKey,
+	"getnewaddress":      handleGetNewAddress,
+	"getrawtransaction":  handleGetRawTransaction,
+	"sendrawtransaction": handleSendRawTransaction,
+	"signrawtransaction": handleSignRawTransaction,
+	"getbalance":         handleGetBalance,
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	var req request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mtx.Lock()
+	fail := s.failures[req.Method] > 0
+	if fail {
+		s.failures[req.Method]--
+	}
+	s.mtx.Unlock()
+	if fail {
+		http.Error(w, "node unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	resp := response{Id: req.Id}
+	s.mtx.Lock()
+	jsonErr := s.errors[req.Method]
+	delete(s.errors, req.Method)
+	s.mtx.Unlock()
+	h, ok := handlers[req.Method]
+	switch {
+	case jsonErr != nil:
+		resp.Error = jsonErr
+	case !ok:
+		resp.Error = &btcjson.Error{Code: ErrMethodNotFound, Message: "Method not found"}
+	default:
+		s.mtx.Lock()
+		resp.Result, resp.Error = h(s, req.Params)
+		s.mtx.Unlock()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func rpcErr(code int, format string, args ...interface{}) *btcjson.Error {
+	return &btcjson.Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// stringParam decodes the ith parameter as a string.
+func stringParam(params []json.RawMessage, i int) (string, *btcjson.Error) {
+	if len(params) <= i {
+		return "", rpcErr(ErrInvalidParams, "Missing parameter %d", i)
+	}
+	var str string
+	if err := json.Unmarshal(params[i], &str); err != nil {
+		return "", rpcErr(ErrInvalidParams, "Parameter %d is not a string", i)
+	}
+	return str, nil
+}
+
+func handleGetDifficulty(s *Server, params []json.RawMessage) (interface{}, *btcjson.Error) {
+	return 1.0, nil
+}
+
+// The chain is only ever its genesis block.
+func handleGetBlockHash(s *Server, params []json.RawMessage) (interface{}, *btcjson.Error) {
+	if len(params) < 1 {
+		return nil, rpcErr(ErrInvalidParams, "Missing parameter 0")
+	}
+	var height int64
+	if err := json.Unmarshal(params[0], &height); err != nil {
+		return nil, rpcErr(ErrInvalidParams, "height must be a number")
+	}
+	if height != 0 || s.NetParams.GenesisHash == nil {
+		return nil, rpcErr(ErrInvalidParameter, "Block height out of range")
+	}
+	return s.NetParams.GenesisHash.String(), nil
+}
+
+func handleListUnspent(s *Server, params []json.RawMessage) (interface{}, *btcjson.Error) {
+	// Like bitcoind, only confirmed outputs are listed by default
+	minConf := int64(1)
+	if len(params) > 0 {
+		if err := json.Unmarshal(params[0], &minConf); err != nil {
+			return nil, rpcErr(ErrInvalidParams, "minconf must be a number")
+		}
+	}
+
+	list := make([]btcjson.ListUnspentResult, 0)
+	for _, op := range s.order {
+		u, ok := s.unspent[op]
+		if !ok || !s.isMine(u.txout.PkScript) {
+			continue
+		}
+		confs := int64(0)
+		if u.confirmed {
+			confs = 1
+		}
+		if confs < minConf {
+			continue
+		}
+		_, addrs, _, _ := btcscript.ExtractPkScriptAddrs(u.txout.PkScript, s.NetParams)
+		list = append(list, btcjson.ListUnspentResult{
+			TxId:          op.Hash.String(),
+			Vout:          op.Index,
+			Address:       addrs[0].EncodeAddress(),
+			ScriptPubKey:  hex.EncodeToString(u.txout.PkScript),
+			Amount:        btcutil.Amount(u.txout.Value).ToUnit(btcutil.AmountBTC),
+			Confirmations: confs,
+		})
+	}
+	return list, nil
+}
+
+func handleDumpPrivKey(s *Server, params []json.RawMessage) (interface{}, *btcjson.Error) {
+	encoded, jsonErr := stringParam(params, 0)
+	if jsonErr != nil {
+		return nil, jsonErr
+	}
+	addr, err := btcutil.DecodeAddress(encoded, s.NetParams)
+	if err != nil {
+		return nil, rpcErr(ErrInvalidAddressOrKey, "Invalid Bitcoin address")
+	}
+	wif, ok := s.keys[addr.EncodeAddress()]
+	if !ok {
+		return nil, rpcErr(ErrWallet, "Private key for address %s is not known", encoded)
+	}
+	return wif.String(), nil
+}
+
+func handleGetNewAddress(s *Server, params []json.RawMessage) (interface{}, *btcjson.Error) {
+	addr, err := s.newAddr()
+	if err != nil {
+		return nil, rpcErr(ErrWallet, "%s", err)
+	}
+	return addr.EncodeAddress(), nil
+}
+
+func handleGetRawTransaction(s *Server, params []json.RawMessage) (interface{}, *btcjson.Error) {
+	txid, jsonErr := stringParam(params, 0)
+	if jsonErr != nil {
+		return nil, jsonErr
+	}
+	hash, err := btcwire.NewShaHashFromStr(txid)
+	if err != nil {
+		return nil, rpcErr(ErrInvalidParameter, "Invalid txid")
+	}
+	msgtx, ok := s.txs[*hash]
+	if !ok {
+		return nil, rpcErr(ErrInvalidAddressOrKey, "No information available about transaction")
+	}
+	var buf bytes.Buffer
+	msgtx.Serialize(&buf)
+	return hex.EncodeToString(buf.Bytes()), nil
+}
+
+func handleSendRawTransaction(s *Server, params []json.RawMessage) (interface{}, *btcjson.Error) {
+	txHex, jsonErr := stringParam(params, 0)
+	if jsonErr != nil {
+		return nil, jsonErr
+	}
+	raw, err := hex.DecodeString(txHex)
+	if err != nil {
+		return nil, rpcErr(ErrDeserialization, "TX decode failed")
+	}
+	msgtx := btcwire.NewMsgTx()
+	if err := msgtx.Deserialize(bytes.NewReader(raw)); err != nil {
+		return nil, rpcErr(ErrDeserialization, "TX decode failed")
+	}
+	hash := btcutil.NewTx(msgtx).Sha()
+
+	if _, known := s.txs[*hash]; known {
+		if _, inPool := s.mempool[*hash]; inPool {
+			return nil, rpcErr(ErrVerifyRejected, "txn-already-in-mempool")
+		}
+		return nil, rpcErr(ErrVerifyInChain, "transaction already in block chain")
+	}
+
+	totalIn := int64(0)
+	for i, txin := range msgtx.TxIn {
+		prev, ok := s.unspent[txin.PreviousOutPoint]
+		if !ok {
+			return nil, rpcErr(ErrVerify, "Missing inputs")
+		}
+		flags := btcscript.ScriptBip16 | btcscript.ScriptCanonicalSignatures
+		engine, err := btcscript.NewScript(txin.SignatureScript, prev.txout.PkScript, i, msgtx, flags)
+		if err != nil {
+			return nil, rpcErr(ErrVerifyRejected, "mandatory-script-verify-flag-failed (%s)", err)
+		}
+		if err := engine.Execute(); err != nil {
+			return nil, rpcErr(ErrVerifyRejected, "mandatory-script-verify-flag-failed (%s)", err)
+		}
+		totalIn += prev.txout.Value
+	}
+
+	totalOut := int64(0)
+	for _, txout := range msgtx.TxOut {
+		totalOut += txout.Value
+	}
+	if totalOut > totalIn {
+		return nil, rpcErr(ErrVerifyRejected, "bad-txns-in-belowout")
+	}
+
+	for _, txin := range msgtx.TxIn {
+		delete(s.unspent, txin.PreviousOutPoint)
+	}
+	s.txs[*hash] = msgtx
+	s.mempool[*hash] = struct{}{}
+	s.addOutputs(hash, msgtx, false)
+	return hash.String(), nil
+}
+
+// The sighash flags signrawtransaction takes by name.
+var sigHashTypes = map[string]btcscript.SigHashType{
+	"ALL":                 btcscript.SigHashAll,
+	"NONE":                btcscript.SigHashNone,
+	"SINGLE":              btcscript.SigHashSingle,
+	"ALL|ANYONECANPAY":    btcscript.SigHashAll | btcscript.SigHashAnyOneCanPay,
+	"NONE|ANYONECANPAY":   btcscript.SigHashNone | btcscript.SigHashAnyOneCanPay,
+	"SINGLE|ANYONECANPAY": btcscript.SigHashSingle | btcscript.SigHashAnyOneCanPay,
+}
+
+// signResult is what signrawtransaction answers with.
+type signResult struct {
+	Hex      string `json:"hex"`
+	Complete bool   `json:"complete"`
+}
+
+// handleSignRawTransaction signs every input it has a key for with the
+// wallet's keys. The pkScripts spent come from the prevtxs given, then from
+// the server's unspents. Private keys passed by the caller are not supported.
+func handleSignRawTransaction(s *Server, params []json.RawMessage) (interface{}, *btcjson.Error) {
+	txHex, jsonErr := stringParam(params, 0)
+	if jsonErr != nil {
+		return nil, jsonErr
+	}
+	raw, err := hex.DecodeString(txHex)
+	if err != nil {
+		return nil, rpcErr(ErrDeserialization, "TX decode failed")
+	}
+	msgtx := btcwire.NewMsgTx()
+	if err := msgtx.Deserialize(bytes.NewReader(raw)); err != nil {
+		return nil, rpcErr(ErrDeserialization, "TX decode failed")
+	}
+
+	prevScripts := make(map[btcwire.OutPoint][]byte)
+	if len(params) > 1 && string(params[1]) != "null" {
+		var inputs []btcjson.RawTxInput
+		if err := json.Unmarshal(params[1], &inputs); err != nil {
+			return nil, rpcErr(ErrInvalidParams, "Parameter 1 is not a list of prevtxs")
+		}
+		for _, input := range inputs {
+			hash, err := btcwire.NewShaHashFromStr(input.Txid)
+			if err != nil {
+				return nil, rpcErr(ErrInvalidParameter, "Invalid txid")
+			}
+			pkScript, err := hex.DecodeString(input.ScriptPubKey)
+			if err != nil {
+				return nil, rpcErr(ErrInvalidParameter, "Invalid scriptPubKey")
+			}
+			prevScripts[*btcwire.NewOutPoint(hash, input.Vout)] = pkScript
+		}
+	}
+	if len(params) > 2 && string(params[2]) != "null" {
+		return nil, rpcErr(ErrInvalidParameter, "Signing with given private keys is not supported")
+	}
+	hashType := btcscript.SigHashAll
+	if len(params) > 3 {
+		name, jsonErr := stringParam(params, 3)
+		if jsonErr != nil {
+			return nil, jsonErr
+		}
+		var ok bool
+		if hashType, ok = sigHashTypes[name]; !ok {
+			return nil, rpcErr(ErrInvalidParameter, "Invalid sighash param")
+		}
+	}
+
+	lookup := func(addr btcutil.Address) (*btcec.PrivateKey, bool, error) {
+		wif, ok := s.keys[addr.EncodeAddress()]
+		if !ok {
+			return nil, false, fmt.Errorf("No key for address %s", addr.EncodeAddress())
+		}
+		return wif.PrivKey, wif.CompressPubKey, nil
+	}
+	complete := true
+	for i, txin := range msgtx.TxIn {
+		pkScript, ok := prevScripts[txin.PreviousOutPoint]
+		if !ok {
+			prev, known := s.unspent[txin.PreviousOutPoint]
+			if !known {
+				complete = false
+				continue
+			}
+			pkScript = prev.txout.PkScript
+		}
+		sigScript, err := btcscript.SignTxOutput(s.NetParams, msgtx, i, pkScript, hashType,
+			btcscript.KeyClosure(lookup), nil, txin.SignatureScript)
+		if err != nil {
+			complete = false
+			continue
+		}
+		txin.SignatureScript = sigScript
+	}
+
+	var buf bytes.Buffer
+	msgtx.Serialize(&buf)
+	return signResult{Hex: hex.EncodeToString(buf.Bytes()), Complete: complete}, nil
+}
+
+func handleGetBalance(s *Server, params []json.RawMessage) (interface{}, *btcjson.Error) {
+	return btcutil.Amount(s.balance()).ToUnit(btcutil.AmountBTC), nil
+}
@@ -0,0 +1,98 @@
+package btcbuilder
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/conformal/btcscript"
+	"github.com/conformal/btcwire"
+)
+
+// Sizes of the pieces FrameData wraps around a payload.
+const (
+	frameLenSize      = 4
+	frameChecksumSize = 4
+)
+
+// FrameData prefixes data with its length and appends a checksum. Pass the
+// result to CreateList so the decoder can drop the padding exactly, even
+// when the data ends in zeros, and notice a corrupted payload.
+func FrameData(data []byte) []byte {
+	framed := make([]byte, frameLenSize, frameLenSize+len(data)+frameChecksumSize)
+	binary.LittleEndian.PutUint32(framed, uint32(len(data)))
+	framed = append(framed, data...)
+	return append(framed, btcwire.DoubleSha256(data)[:frameChecksumSize]...)
+}
+
+// UnframeData reverses FrameData. Anything past the end of the frame is
+// padding and ignored.
+func UnframeData(raw []byte) ([]byte, error) {
+	if len(raw) < frameLenSize+frameChecksumSize {
+		return nil, errors.New("Too short to hold a frame")
+	}
+	size := int(binary.LittleEndian.Uint32(raw))
+	if size > len(raw)-frameLenSize-frameChecksumSize {
+		return nil, fmt.Errorf("Frame claims %d bytes but only %d follow", size, len(raw)-frameLenSize-frameChecksumSize)
+	}
+	data := raw[frameLenSize : frameLenSize+size]
+	checksum := raw[frameLenSize+size : frameLenSize+size+frameChecksumSize]
+	if !bytes.Equal(checksum, btcwire.DoubleSha256(data)[:frameChecksumSize]) {
+		return nil, errors.New("Frame checksum does not match")
+	}
+	return data, nil
+}
+
+// DecodeList is the inverse of CreateListN. It joins the slots that follow
+// the first numKeys of every entry in list. Unframed data has its zero padding
+// trimmed, which also eats any zeros the data itself ended with.
+func DecodeList(list [][][]byte, numKeys int, framed bool) ([]byte, error) {
+	var buf bytes.Buffer
+	for i, pubkeys := range list {
+		if numKeys >= len(pubkeys) {
+			return nil, fmt.Errorf("Entry %d has %d slots, none after %d keys", i, len(pubkeys), numKeys)
+		}
+		for _, slot := range pubkeys[numKeys:] {
+			buf.Write(slot)
+		}
+	}
+	if framed {
+		return UnframeData(buf.Bytes())
+	}
+	return bytes.TrimRight(buf.Bytes(), "\x00"), nil
+}
+
+// ExtractList collects the key lists of the bare multisig txouts in txs, in
+// order. Other txouts are skipped.
+func ExtractList(txs ...*btcwire.MsgTx) ([][][]byte, error) {
+	list := make([][][]byte, 0)
+	for _, msgtx := range txs {
+		for _, txout := range msgtx.TxOut {
+			if btcscript.GetScriptClass(txout.PkScript) != btcscript.MultiSigTy {
+				continue
+			}
+			// Only the keys are pushed, M and N are small int opcodes
+			pubkeys, err := btcscript.PushedData(txout.PkScript)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, pubkeys)
+		}
+	}
+	return list, nil
+}
+
+// ExtractData recovers the data a MultiSigBuilder embedded in txs with a list
+// from CreateList. numKeys is the number of real keys in front of the data in
+// every txout and framed says whether the data went through FrameData.
+func ExtractData(numKeys int, framed bool, txs ...*btcwire.MsgTx) ([]byte, error) {
+	list, err := ExtractList(txs...)
+	if err != nil {
+		return nil, err
+	}
+	if len(list) < 1 {
+		return nil, errors.New("No multisig txouts to extract from")
+	}
+	return DecodeList(list, numKeys, framed)
+}
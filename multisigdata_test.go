@@ -0,0 +1,92 @@
+package btcbuilder
+
+import (
+	"bytes"
+	"testing"
+	"testing/quick"
+
+	"github.com/conformal/btcnet"
+	"github.com/conformal/btcutil"
+	"github.com/conformal/btcwire"
+)
+
+// embed runs data through CreateListN and into the txouts of a tx the way a
+// MultiSigBuilder would.
+func embed(data []byte, n, slotSize int, keys []*btcutil.WIF) (*btcwire.MsgTx, error) {
+	list, err := CreateListN(data, n, slotSize, keys...)
+	if err != nil {
+		return nil, err
+	}
+	msB := &MultiSigBuilder{M: 1, N: int64(n)}
+	msgtx := btcwire.NewMsgTx()
+	for _, pubkeys := range list {
+		msgtx.AddTxOut(btcwire.NewTxOut(0, msB.pkScript(pubkeys)))
+	}
+	return msgtx, nil
+}
+
+// layout turns arbitrary values from quick into a valid CreateListN layout
+// with n up to 16, the most a standard script can have.
+func layout(n, numKeys uint8, compressed bool) (int, int, int) {
+	slotSize := uncompressedKeySize
+	if compressed {
+		slotSize = compressedKeySize
+	}
+	nKeys := int(n%15) + 2
+	return nKeys, int(numKeys) % nKeys, slotSize
+}
+
+func TestFramedRoundTrip(t *testing.T) {
+	net := btcnet.TestNet3Params
+	roundTrip := func(data []byte, n, numKeys uint8, compressed bool) bool {
+		nKeys, numReal, slotSize := layout(n, numKeys, compressed)
+		keys := make([]*btcutil.WIF, numReal)
+		for i := range keys {
+			keys[i] = newWifKeyPair(&net)
+		}
+
+		msgtx, err := embed(FrameData(data), nKeys, slotSize, keys)
+		if err != nil {
+			t.Log(err)
+			return false
+		}
+		out, err := ExtractData(numReal, true, msgtx)
+		if err != nil {
+			t.Log(err)
+			return false
+		}
+		return bytes.Equal(out, data)
+	}
+	if err := quick.Check(roundTrip, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestUnframedRoundTrip(t *testing.T) {
+	// Without a frame only data that does not end in zeros survives
+	roundTrip := func(data []byte, n, numKeys uint8, compressed bool) bool {
+		data = append(data, 0x01)
+		nKeys, _, slotSize := layout(n, numKeys, compressed)
+		msgtx, err := embed(data, nKeys, slotSize, nil)
+		if err != nil {
+			t.Log(err)
+			return false
+		}
+		out, err := ExtractData(0, false, msgtx)
+		return err == nil && bytes.Equal(out, data)
+	}
+	if err := quick.Check(roundTrip, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestUnframeCatchesCorruption(t *testing.T) {
+	framed := FrameData([]byte("archived payload"))
+	framed[frameLenSize] ^= 0xff
+	if _, err := UnframeData(framed); err == nil {
+		t.Error("Corrupted frame decoded without error")
+	}
+	if _, err := UnframeData(framed[:frameLenSize+2]); err == nil {
+		t.Error("Truncated frame decoded without error")
+	}
+}
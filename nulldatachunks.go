@@ -0,0 +1,175 @@
+package btcbuilder
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/conformal/btcscript"
+	"github.com/conformal/btcwire"
+)
+
+// Every chunk opens with a header that ties it to the rest of its payload.
+//
+//	ref   [8]byte  first bytes of the double sha256 of the whole payload
+//	seq   uint16   the chunk's place, counting from zero
+//	total uint16   the number of chunks in the payload
+const (
+	chunkRefSize    = 8
+	chunkHeaderSize = chunkRefSize + 2 + 2
+	maxChunks       = 0xffff
+)
+
+// ChunkData cuts data into chunks that each fit in maxPayload bytes including
+// their header. A chunk can be no larger than a single push.
+func ChunkData(data []byte, maxPayload int) ([][]byte, error) {
+	if maxPayload > maxPushSize {
+		return nil, fmt.Errorf("A %d byte payload is over the %d byte push limit", maxPayload, maxPushSize)
+	}
+	room := maxPayload - chunkHeaderSize
+	if room < 1 {
+		return nil, fmt.Errorf("A %d byte payload leaves no room past the %d byte chunk header", maxPayload, chunkHeaderSize)
+	}
+	total := (len(data) + room - 1) / room
+	if total == 0 {
+		total = 1
+	}
+	if total > maxChunks {
+		return nil, fmt.Errorf("Data needs %d chunks, over %d", total, maxChunks)
+	}
+
+	ref := btcwire.DoubleSha256(data)[:chunkRefSize]
+	chunks := make([][]byte, total)
+	for seq := range chunks {
+		n := room
+		if len(data) < n {
+			n = len(data)
+		}
+		chunk := make([]byte, chunkHeaderSize, chunkHeaderSize+n)
+		copy(chunk, ref)
+		binary.LittleEndian.PutUint16(chunk[chunkRefSize:], uint16(seq))
+		binary.LittleEndian.PutUint16(chunk[chunkRefSize+2:], uint16(total))
+		chunks[seq] = append(chunk, data[:n]...)
+		data = data[n:]
+	}
+	return chunks, nil
+}
+
+// NewNullDataChunks returns a NullDataBuilder for every tx the chunks of data
+// need. A tx holds as many chunks as the params' Policy allows OP_RETURN
+// outputs. Read them back with a Reassembler.
+func NewNullDataChunks(params BuilderParams, data []byte, change bool, maxPayload int) ([]*NullDataBuilder, error) {
+	chunks, err := ChunkData(data, maxPayload)
+	if err != nil {
+		return nil, err
+	}
+	// Every chunk but the last fills maxPayload, so the builders cut the
+	// joined chunks back apart on their boundaries
+	perTx := (&NullDataBuilder{Params: params}).maxOutputs()
+	builders := make([]*NullDataBuilder, 0, (len(chunks)+perTx-1)/perTx)
+	for len(chunks) > 0 {
+		n := perTx
+		if len(chunks) < n {
+			n = len(chunks)
+		}
+		builder := NewNullData(params, bytes.Join(chunks[:n], nil), change)
+		builder.MaxPayload = maxPayload
+		builders = append(builders, builder)
+		chunks = chunks[n:]
+	}
+	return builders, nil
+}
+
+// NullData joins the payloads of msgtx's OP_RETURN outputs in order, which
+// is how a NullDataBuilder spreads Data that does not fit in one.
+func NullData(msgtx *btcwire.MsgTx) []byte {
+	var data []byte
+	for _, txout := range msgtx.TxOut {
+		if !isNullData(txout.PkScript) {
+			continue
+		}
+		pushed, err := btcscript.PushedData(txout.PkScript[1:])
+		if err != nil {
+			continue
+		}
+		for _, push := range pushed {
+			data = append(data, push...)
+		}
+	}
+	return data
+}
+
+// A Reassembler collects chunks from txs, in any order, until it can put
+// their payloads back together.
+type Reassembler struct {
+	partial map[string][][]byte // chunks by ref, nil until a chunk arrives
+}
+
+func NewReassembler() *Reassembler {
+	return &Reassembler{partial: make(map[string][][]byte)}
+}
+
+// AddTx takes the chunks out of msgtx's OP_RETURN outputs and returns every
+// payload they complete. Outputs that do not hold a chunk are ignored.
+func (r *Reassembler) AddTx(msgtx *btcwire.MsgTx) ([][]byte, error) {
+	done := make([][]byte, 0)
+	for _, txout := range msgtx.TxOut {
+		if !isNullData(txout.PkScript) {
+			continue
+		}
+		pushed, err := btcscript.PushedData(txout.PkScript[1:])
+		if err != nil || len(pushed) != 1 {
+			continue
+		}
+		data, err := r.AddChunk(pushed[0])
+		if err != nil {
+			return nil, err
+		}
+		if data != nil {
+			done = append(done, data)
+		}
+	}
+	return done, nil
+}
+
+// AddChunk adds a single chunk. Once the last chunk of a payload arrives the
+// payload is checked against its ref and returned.
+func (r *Reassembler) AddChunk(chunk []byte) ([]byte, error) {
+	if len(chunk) < chunkHeaderSize {
+		return nil, nil
+	}
+	ref := string(chunk[:chunkRefSize])
+	seq := int(binary.LittleEndian.Uint16(chunk[chunkRefSize:]))
+	total := int(binary.LittleEndian.Uint16(chunk[chunkRefSize+2:]))
+	if total == 0 || seq >= total {
+		return nil, nil
+	}
+
+	parts, ok := r.partial[ref]
+	if !ok {
+		parts = make([][]byte, total)
+		r.partial[ref] = parts
+	}
+	if len(parts) != total {
+		return nil, fmt.Errorf("Chunk %d of %x claims %d chunks, earlier ones said %d", seq, ref, total, len(parts))
+	}
+	parts[seq] = chunk[chunkHeaderSize:]
+
+	for _, part := range parts {
+		if part == nil {
+			return nil, nil
+		}
+	}
+	delete(r.partial, ref)
+	data := bytes.Join(parts, nil)
+	if !bytes.Equal(btcwire.DoubleSha256(data)[:chunkRefSize], []byte(ref)) {
+		return nil, errors.New("Reassembled payload does not match its ref")
+	}
+	return data, nil
+}
+
+// Pending is the number of payloads still waiting on chunks.
+func (r *Reassembler) Pending() int {
+	return len(r.partial)
+}
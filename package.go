@@ -0,0 +1,162 @@
+package btcbuilder
+
+import (
+	"errors"
+
+	"github.com/conformal/btcutil"
+	"github.com/conformal/btcwire"
+)
+
+// A Package is a set of transactions built offline where later ones may spend
+// the txouts of earlier ones, like a fanout and the txs that consume it.
+type Package struct {
+	Params BuilderParams
+	txs    []*btcwire.MsgTx
+	hashes []*btcwire.ShaHash
+}
+
+// NewPackage starts an empty package. The builders added to it must share the
+// params' UtxoSource, which has to be a TxApplier such as a UtxoCache or a
+// MemSource.
+func NewPackage(params BuilderParams) *Package {
+	pkg := Package{
+		Params: params,
+		txs:    make([]*btcwire.MsgTx, 0),
+		hashes: make([]*btcwire.ShaHash, 0),
+	}
+	return &pkg
+}
+
+// applier returns the source txs in the package are applied to.
+func (pkg *Package) applier() (TxApplier, error) {
+	applier, ok := pkg.Params.source().(TxApplier)
+	if !ok {
+		return nil, errors.New("UtxoSource cannot spend txs that have not been broadcast")
+	}
+	return applier, nil
+}
+
+// Build runs builder and applies the result so that the next builder can
+// spend its txouts. Until the node accepts it the tx is only a promise, so a
+// package that is not broadcast in full has to be given back with Rollback
+// or with the Broadcaster, which reverts whatever does not go out.
+func (pkg *Package) Build(builder TxBuilder) (*btcwire.MsgTx, error) {
+	applier, err := pkg.applier()
+	if err != nil {
+		return nil, err
+	}
+	msgtx, err := builder.Build()
+	if err != nil {
+		return nil, err
+	}
+	applier.Apply(msgtx)
+	pkg.add(msgtx)
+	return msgtx, nil
+}
+
+// Add puts a tx built elsewhere into the package.
+func (pkg *Package) Add(msgtx *btcwire.MsgTx) error {
+	applier, err := pkg.applier()
+	if err != nil {
+		return err
+	}
+	applier.Apply(msgtx)
+	pkg.add(msgtx)
+	return nil
+}
+
+// Rollback undoes the package's txs in the source they were applied to and
+// releases their inputs, leaving the package empty.
+func (pkg *Package) Rollback() error {
+	all := make([]int, len(pkg.txs))
+	for i := range all {
+		all[i] = i
+	}
+	if err := pkg.revert(all); err != nil {
+		return err
+	}
+	for _, msgtx := range pkg.txs {
+		releaseTx(msgtx, pkg.Params)
+	}
+	pkg.txs = pkg.txs[:0]
+	pkg.hashes = pkg.hashes[:0]
+	return nil
+}
+
+// revert undoes the txs at idxs in the source, children ahead of parents so
+// that no tx brings back a txout of a tx that is already gone.
+func (pkg *Package) revert(idxs []int) error {
+	applier, err := pkg.applier()
+	if err != nil {
+		return err
+	}
+	wanted := make(map[int]bool, len(idxs))
+	for _, idx := range idxs {
+		wanted[idx] = true
+	}
+	order := pkg.order()
+	for i := len(order) - 1; i >= 0; i-- {
+		if wanted[order[i]] {
+			applier.Revert(pkg.txs[order[i]])
+		}
+	}
+	return nil
+}
+
+func (pkg *Package) add(msgtx *btcwire.MsgTx) {
+	pkg.txs = append(pkg.txs, msgtx)
+	pkg.hashes = append(pkg.hashes, btcutil.NewTx(msgtx).Sha())
+}
+
+// Len is the number of txs in the package.
+func (pkg *Package) Len() int {
+	return len(pkg.txs)
+}
+
+// Parents returns the indices of the txs in the package that the tx at idx
+// spends from.
+func (pkg *Package) Parents(idx int) []int {
+	parents := make([]int, 0)
+	seen := make(map[int]bool)
+	for _, txin := range pkg.txs[idx].TxIn {
+		for j, hash := range pkg.hashes {
+			if j != idx && !seen[j] && hash.IsEqual(&txin.PreviousOutPoint.Hash) {
+				seen[j] = true
+				parents = append(parents, j)
+			}
+		}
+	}
+	return parents
+}
+
+// Sorted returns the txs with every parent ahead of its children. Txs with no
+// order between them keep the order they were added in.
+func (pkg *Package) Sorted() []*btcwire.MsgTx {
+	sorted := make([]*btcwire.MsgTx, 0, len(pkg.txs))
+	for _, idx := range pkg.order() {
+		sorted = append(sorted, pkg.txs[idx])
+	}
+	return sorted
+}
+
+// order is a topological sort of the package's indices. Txs can only spend
+// txs whose hash already exists, so the graph never has a cycle.
+func (pkg *Package) order() []int {
+	placed := make([]bool, len(pkg.txs))
+	order := make([]int, 0, len(pkg.txs))
+	var visit func(idx int)
+	visit = func(idx int) {
+		if placed[idx] {
+			return
+		}
+		placed[idx] = true
+		for _, parent := range pkg.Parents(idx) {
+			visit(parent)
+		}
+		order = append(order, idx)
+	}
+	for idx := range pkg.txs {
+		visit(idx)
+	}
+	return order
+}
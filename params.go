@@ -0,0 +1,284 @@
+package btcbuilder
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/conformal/btcnet"
+	"github.com/conformal/btcrpcclient"
+)
+
+// The most a sane FeeRate can be, 0.1 BTC a kilobyte.
+const maxFeeRate = 10000
+
+// paramsConfig collects what the Options ask for before NewParams puts the
+// BuilderParams together.
+type paramsConfig struct {
+	params   BuilderParams
+	confPath string
+	node     *NodeConfig
+	net      *btcnet.Params // The network asked for, nil takes the node's
+	offline  bool
+	feeSet   bool // FeeRate was chosen, so no node estimator
+	noCache  bool
+}
+
+// An Option changes how NewParams builds BuilderParams.
+type Option func(*paramsConfig) error
+
+// WithConf reads the node's settings from the conf file at path instead of
+// the default one.
+func WithConf(path string) Option {
+	return func(cfg *paramsConfig) error {
+		cfg.confPath = path
+		return nil
+	}
+}
+
+// WithNodeConfig connects to the node cfg describes without reading a conf.
+func WithNodeConfig(node *NodeConfig) Option {
+	return func(cfg *paramsConfig) error {
+		cfg.node = node
+		return nil
+	}
+}
+
+// Offline builds params that never talk to a node. Unless WithSource is given
+// funds come from an empty MemSource.
+func Offline() Option {
+	return func(cfg *paramsConfig) error {
+		cfg.offline = true
+		return nil
+	}
+}
+
+// WithNet sets the network. With a node NewParams refuses to build unless the
+// node is on it.
+func WithNet(net *btcnet.Params) Option {
+	return func(cfg *paramsConfig) error {
+		cfg.net = net
+		return nil
+	}
+}
+
+// WithFeeRate pays a fixed rate in satoshis per byte instead of estimating.
+func WithFeeRate(rate int64) Option {
+	return func(cfg *paramsConfig) error {
+		cfg.params.FeeRate = rate
+		cfg.params.FeeEstimator = nil
+		cfg.feeSet = true
+		return nil
+	}
+}
+
+// WithFeeEstimator estimates the rate needed for confirmation within
+// confTarget blocks. FeeRate stays the fallback.
+func WithFeeEstimator(est FeeEstimator, confTarget int) Option {
+	return func(cfg *paramsConfig) error {
+		cfg.params.FeeEstimator = est
+		cfg.params.ConfTarget = confTarget
+		cfg.feeSet = true
+		return nil
+	}
+}
+
+func WithDust(amnt int64) Option {
+	return func(cfg *paramsConfig) error {
+		cfg.params.DustAmnt = amnt
+		return nil
+	}
+}
+
+func WithInTarget(amnt int64) Option {
+	return func(cfg *paramsConfig) error {
+		cfg.params.InTarget = amnt
+		return nil
+	}
+}
+
+func WithLogger(logger *log.Logger) Option {
+	return func(cfg *paramsConfig) error {
+		cfg.params.Logger = logger
+		return nil
+	}
+}
+
+// WithSource draws unspents from src. It signs as well when it is a Signer.
+func WithSource(src UtxoSource) Option {
+	return func(cfg *paramsConfig) error {
+		cfg.params.Source = src
+		return nil
+	}
+}
+
+func WithSigner(signer Signer) Option {
+	return func(cfg *paramsConfig) error {
+		cfg.params.Signer = signer
+		return nil
+	}
+}
+
+func WithCoinSelector(selector CoinSelector) Option {
+	return func(cfg *paramsConfig) error {
+		cfg.params.CoinSelector = selector
+		return nil
+	}
+}
+
+func WithPolicy(policy Policy) Option {
+	return func(cfg *paramsConfig) error {
+		cfg.params.Policy = &policy
+		return nil
+	}
+}
+
+func WithReservations(store ReservationStore) Option {
+	return func(cfg *paramsConfig) error {
+		cfg.params.Reservations = store
+		return nil
+	}
+}
+
+// WithoutCache lists unspents from the source on every pick.
+func WithoutCache() Option {
+	return func(cfg *paramsConfig) error {
+		cfg.noCache = true
+		return nil
+	}
+}
+
+// NewParams is the one way to configure the library. Without options it reads
+// the default conf file, connects to the node and fills in the defaults every
+// builder expects: a 10 sat/byte fallback fee, 546 satoshi dust, a 100000
+// satoshi InTarget, the node's wallet as source and signer, in memory
+// reservations and a utxo cache. Fee estimates are taken once through a
+// FeeSnapshot. The result is checked before it is returned.
+func NewParams(opts ...Option) (BuilderParams, error) {
+	cfg := &paramsConfig{
+		params: BuilderParams{
+			FeeRate:    10,
+			ConfTarget: 6,
+			DustAmnt:   546,
+			InTarget:   100000,
+			Logger:     log.New(os.Stdout, "", log.Ltime|log.Llongfile),
+		},
+	}
+	for _, opt := range opts {
+		if err := opt(cfg); err != nil {
+			return BuilderParams{}, err
+		}
+	}
+
+	params := cfg.params
+	if cfg.offline {
+		if cfg.net == nil {
+			return BuilderParams{}, errors.New("Offline params need a network")
+		}
+		params.NetParams = cfg.net
+		if params.Source == nil {
+			params.Source = NewMemSource(cfg.net)
+		}
+	} else {
+		node, err := cfg.nodeConfig()
+		if err != nil {
+			return BuilderParams{}, err
+		}
+		client, err := makeRpcClient(node.ConnCfg)
+		if err != nil {
+			return BuilderParams{}, err
+		}
+		net, err := cfg.nodeNet(client, node)
+		if err != nil {
+			return BuilderParams{}, err
+		}
+		params.Client = client
+		params.NetParams = net
+		if params.Source == nil {
+			params.Source = NewRPCSource(client, net)
+		}
+		if !cfg.feeSet {
+			fallback := NewStaticFeeEstimator(DefaultFeeTable)
+			params.FeeEstimator = NewRPCFeeEstimator(node.ConnCfg, fallback)
+		}
+	}
+
+	if params.FeeEstimator != nil {
+		if _, ok := params.FeeEstimator.(*FeeSnapshot); !ok {
+			params.FeeEstimator = NewFeeSnapshot(params.FeeEstimator)
+		}
+	}
+
+	if params.Signer == nil {
+		if signer, ok := params.Source.(Signer); ok {
+			params.Signer = signer
+		} else {
+			params.Signer = NewSourceSigner(params.Source)
+		}
+	}
+	if params.Reservations == nil {
+		params.Reservations = NewMemReservations(DefaultReservationTTL)
+	}
+	if !cfg.noCache {
+		params.Cache = NewUtxoCache(params.Source)
+	}
+
+	if err := params.Validate(); err != nil {
+		return BuilderParams{}, err
+	}
+	return params, nil
+}
+
+// nodeConfig loads the node's settings unless they were given.
+func (cfg *paramsConfig) nodeConfig() (*NodeConfig, error) {
+	if cfg.node != nil {
+		return cfg.node, nil
+	}
+	return LoadConfig(cfg.confPath)
+}
+
+// nodeNet is the network the node is actually on. It must be the one asked
+// for, while a conf that names another network is only worth a warning.
+func (cfg *paramsConfig) nodeNet(client *btcrpcclient.Client, node *NodeConfig) (*btcnet.Params, error) {
+	net, err := DetectNet(client)
+	if err != nil {
+		return nil, fmt.Errorf("Could not detect the node's network: %s", err)
+	}
+	if cfg.net != nil && cfg.net.Name != net.Name {
+		return nil, fmt.Errorf("Asked for %s but the node is on %s", cfg.net.Name, net.Name)
+	}
+	if node.NetParams != nil && node.NetParams.Name != net.Name && cfg.params.Logger != nil {
+		cfg.params.Logger.Printf("The conf is for %s but the node is on %s\n", node.NetParams.Name, net.Name)
+	}
+	return net, nil
+}
+
+// Validate checks that params are consistent enough to build with.
+func (params BuilderParams) Validate() error {
+	if params.NetParams == nil {
+		return errors.New("No NetParams set")
+	}
+	if params.Logger == nil {
+		return errors.New("No Logger set")
+	}
+	if params.DustAmnt <= 0 {
+		return fmt.Errorf("DustAmnt of %d is not positive", params.DustAmnt)
+	}
+	if params.InTarget != 0 && params.InTarget < params.DustAmnt {
+		return fmt.Errorf("InTarget of %d is below DustAmnt of %d", params.InTarget, params.DustAmnt)
+	}
+	if params.FeeRate < 0 || params.FeeRate > maxFeeRate {
+		return fmt.Errorf("FeeRate of %d is outside 0 to %d sat/byte", params.FeeRate, maxFeeRate)
+	}
+	if params.FeeRate == 0 && params.FeeEstimator == nil {
+		return errors.New("A FeeRate of zero makes zero fee txs, set one or a FeeEstimator")
+	}
+	if params.FeeEstimator != nil && params.ConfTarget < 1 {
+		return errors.New("A FeeEstimator needs a ConfTarget of at least one block")
+	}
+	if params.Source == nil && params.Client == nil {
+		return errors.New("No Source or Client to fund txs from")
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package btcbuilder
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/conformal/btcscript"
+	"github.com/conformal/btcwire"
+)
+
+// A Policy describes the relay rules a node holds transactions to. Whether a
+// built tx is relayed depends on the policy of the nodes it is sent to, not
+// on consensus.
+type Policy struct {
+	Name              string
+	DustThreshold     int64 // Spendable outputs worth less than this are dust
+	MaxDataCarrier    int   // The largest payload an OP_RETURN output may push
+	MaxDataOutputs    int   // The number of OP_RETURN outputs allowed in one tx
+	AllowBareMultisig bool
+	MaxMultisigKeys   int // The most keys a bare multisig output may list
+	MaxTxSize         int
+	MaxSigOps         int
+	MaxSigScriptSize  int
+}
+
+// StandardPolicy mirrors the defaults of bitcoind 0.9.
+var StandardPolicy = Policy{
+	Name:              "standard",
+	DustThreshold:     546,
+	MaxDataCarrier:    40,
+	MaxDataOutputs:    1,
+	AllowBareMultisig: true,
+	MaxMultisigKeys:   3,
+	MaxTxSize:         100000,
+	MaxSigOps:         4000,
+	MaxSigScriptSize:  1650,
+}
+
+// DataCarrier80Policy is StandardPolicy with the 80 byte OP_RETURN limit that
+// later bitcoind releases adopted.
+var DataCarrier80Policy = func() Policy {
+	p := StandardPolicy
+	p.Name = "datacarrier80"
+	p.MaxDataCarrier = 80
+	return p
+}()
+
+// NoBareMultisigPolicy models nodes run with -permitbaremultisig=0.
+var NoBareMultisigPolicy = func() Policy {
+	p := StandardPolicy
+	p.Name = "nobaremultisig"
+	p.AllowBareMultisig = false
+	return p
+}()
+
+// A Violation is a single broken rule. Index points at the offending txin or
+// txout and is -1 for rules that apply to the whole tx.
+type Violation struct {
+	Rule   string
+	Index  int
+	Detail string
+}
+
+func (v Violation) String() string {
+	if v.Index < 0 {
+		return fmt.Sprintf("%s: %s", v.Rule, v.Detail)
+	}
+	return fmt.Sprintf("%s[%d]: %s", v.Rule, v.Index, v.Detail)
+}
+
+// PolicyError is returned by Send when a built tx breaks the params' Policy.
+type PolicyError struct {
+	Policy     string
+	Violations []Violation
+}
+
+func (e *PolicyError) Error() string {
+	strs := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		strs[i] = v.String()
+	}
+	return fmt.Sprintf("Tx breaks %s policy: %s", e.Policy, strings.Join(strs, ", "))
+}
+
+// PolicyChecker evaluates transactions against a Policy.
+type PolicyChecker struct {
+	Policy Policy
+}
+
+func NewPolicyChecker(policy Policy) *PolicyChecker {
+	return &PolicyChecker{Policy: policy}
+}
+
+// Check reports every rule msgtx breaks. An empty result means the tx is
+// standard under the checker's policy.
+func (pc *PolicyChecker) Check(msgtx *btcwire.MsgTx) []Violation {
+	p := pc.Policy
+	violations := make([]Violation, 0)
+	add := func(rule string, idx int, format string, args ...interface{}) {
+		violations = append(violations, Violation{
+			Rule:   rule,
+			Index:  idx,
+			Detail: fmt.Sprintf(format, args...),
+		})
+	}
+
+	if size := msgtx.SerializeSize(); size > p.MaxTxSize {
+		add("tx-size", -1, "%d bytes is over %d", size, p.MaxTxSize)
+	}
+
+	sigOps := 0
+	for i, txin := range msgtx.TxIn {
+		if size := len(txin.SignatureScript); size > p.MaxSigScriptSize {
+			add("scriptsig-size", i, "%d bytes is over %d", size, p.MaxSigScriptSize)
+		}
+		if !btcscript.IsPushOnlyScript(txin.SignatureScript) {
+			add("scriptsig-not-pushonly", i, "scriptSig does more than push data")
+		}
+		sigOps += btcscript.GetSigOpCount(txin.SignatureScript)
+	}
+
+	dataOuts := 0
+	for i, txout := range msgtx.TxOut {
+		sigOps += btcscript.GetSigOpCount(txout.PkScript)
+
+		// Data carrying outputs are judged on their payload alone
+		if isNullData(txout.PkScript) {
+			dataOuts++
+			payload, err := btcscript.PushedData(txout.PkScript[1:])
+			if err != nil {
+				add("scriptpubkey", i, "malformed OP_RETURN output")
+				continue
+			}
+			size := 0
+			for _, push := range payload {
+				size += len(push)
+			}
+			if size > p.MaxDataCarrier {
+				add("datacarrier-size", i, "%d byte payload is over %d", size, p.MaxDataCarrier)
+			}
+			continue
+		}
+
+		switch btcscript.GetScriptClass(txout.PkScript) {
+		case btcscript.NonStandardTy:
+			add("scriptpubkey", i, "nonstandard output script")
+		case btcscript.MultiSigTy:
+			if !p.AllowBareMultisig {
+				add("bare-multisig", i, "bare multisig outputs are not relayed")
+			}
+			// The script closes with OP_N OP_CHECKMULTISIG
+			numKeys := int(txout.PkScript[len(txout.PkScript)-2]) - (btcscript.OP_1 - 1)
+			if numKeys > p.MaxMultisigKeys {
+				add("scriptpubkey", i, "%d keys is over %d", numKeys, p.MaxMultisigKeys)
+			}
+		}
+
+		if txout.Value < p.DustThreshold {
+			add("dust", i, "%d satoshi is under %d", txout.Value, p.DustThreshold)
+		}
+	}
+
+	if dataOuts > p.MaxDataOutputs {
+		add("multi-op-return", -1, "%d OP_RETURN outputs is over %d", dataOuts, p.MaxDataOutputs)
+	}
+	if sigOps > p.MaxSigOps {
+		add("sigops", -1, "%d sigops is over %d", sigOps, p.MaxSigOps)
+	}
+	return violations
+}
+
+// isNullData reports whether pkScript is a provably unspendable data carrier.
+// It is more lenient than btcscript's classifier which rejects payloads over
+// its own size limit.
+func isNullData(pkScript []byte) bool {
+	return len(pkScript) > 0 && pkScript[0] == btcscript.OP_RETURN
+}
@@ -0,0 +1,82 @@
+package btcbuilder
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/conformal/btcjson"
+	"github.com/conformal/btcrpcclient"
+)
+
+// How long rpcRequest waits on a node before giving up.
+const rpcTimeout = 30 * time.Second
+
+type rawRequest struct {
+	JsonRpc string        `json:"jsonrpc"`
+	Id      int           `json:"id"`
+	Method  string        `json:"method"`
+	Params  []interface{} `json:"params"`
+}
+
+type rawResponse struct {
+	Result json.RawMessage `json:"result"`
+	Error  *btcjson.Error  `json:"error"`
+}
+
+// rpcRequest posts a single json-rpc command to the node described by
+// connCfg. It covers the calls btcrpcclient has no method for. Errors the node
+// reports are returned as a *btcjson.Error.
+func rpcRequest(connCfg *btcrpcclient.ConnConfig, method string, params ...interface{}) (json.RawMessage, error) {
+	if params == nil {
+		params = []interface{}{}
+	}
+	body, err := json.Marshal(rawRequest{
+		JsonRpc: "1.0",
+		Id:      1,
+		Method:  method,
+		Params:  params,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	scheme := "https"
+	client := &http.Client{Timeout: rpcTimeout}
+	if connCfg.DisableTLS {
+		scheme = "http"
+	} else if len(connCfg.Certificates) > 0 {
+		pool := x509.NewCertPool()
+		pool.AppendCertsFromPEM(connCfg.Certificates)
+		client.Transport = &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: pool},
+		}
+	}
+
+	url := fmt.Sprintf("%s://%s/%s", scheme, connCfg.Host, connCfg.Endpoint)
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.SetBasicAuth(connCfg.User, connCfg.Pass)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var rawResp rawResponse
+	if err := json.NewDecoder(resp.Body).Decode(&rawResp); err != nil {
+		return nil, fmt.Errorf("%s: bad response from node (%s): %s", method, resp.Status, err)
+	}
+	if rawResp.Error != nil {
+		return nil, rawResp.Error
+	}
+	return rawResp.Result, nil
+}
@@ -0,0 +1,299 @@
+package btcbuilder
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/conformal/btcwire"
+)
+
+// DefaultReservationTTL is how long an unspent stays reserved when nothing
+// releases it. By then the tx that spent it has either confirmed or is not
+// coming.
+const DefaultReservationTTL = 24 * time.Hour
+
+// A ReservationStore remembers which unspents builders have claimed so that
+// two transactions never try to spend the same outpoint. Stores are safe for
+// use by builders running in separate goroutines.
+type ReservationStore interface {
+	// ReserveSelected hands choose the unspents that are not claimed and
+	// claims whatever it picks, with no other claim able to happen in between.
+	ReserveSelected(unspents []*TxInParams, choose Chooser) ([]*TxInParams, error)
+	// Reserve claims op. It reports false if op is already claimed.
+	Reserve(op *btcwire.OutPoint) (bool, error)
+	// Reserved reports whether op is currently claimed.
+	Reserved(op *btcwire.OutPoint) bool
+	// Release hands op back, for when a build fails or a tx is rejected.
+	Release(op *btcwire.OutPoint) error
+}
+
+// A Chooser picks the unspents a tx will spend out of those available.
+type Chooser func(available []*TxInParams) ([]*TxInParams, error)
+
+// MemReservations keeps reservations in memory. They are lost on restart.
+type MemReservations struct {
+	TTL      time.Duration // Zero means reservations never expire
+	mtx      sync.Mutex
+	reserved map[string]time.Time
+}
+
+func NewMemReservations(ttl time.Duration) *MemReservations {
+	mr := MemReservations{
+		TTL:      ttl,
+		reserved: make(map[string]time.Time),
+	}
+	return &mr
+}
+
+func (mr *MemReservations) ReserveSelected(unspents []*TxInParams, choose Chooser) ([]*TxInParams, error) {
+	mr.mtx.Lock()
+	defer mr.mtx.Unlock()
+	return mr.reserveSelected(unspents, choose, nil)
+}
+
+func (mr *MemReservations) Reserve(op *btcwire.OutPoint) (bool, error) {
+	mr.mtx.Lock()
+	defer mr.mtx.Unlock()
+	return mr.reserve([]*btcwire.OutPoint{op}, nil)
+}
+
+func (mr *MemReservations) Reserved(op *btcwire.OutPoint) bool {
+	mr.mtx.Lock()
+	defer mr.mtx.Unlock()
+	return mr.held(outPointStr(op))
+}
+
+func (mr *MemReservations) Release(op *btcwire.OutPoint) error {
+	mr.mtx.Lock()
+	defer mr.mtx.Unlock()
+	delete(mr.reserved, outPointStr(op))
+	return nil
+}
+
+// The methods below expect mr.mtx to be held.
+
+func (mr *MemReservations) held(key string) bool {
+	at, ok := mr.reserved[key]
+	return ok && !expired(at, mr.TTL)
+}
+
+// reserve claims all of ops or none of them. commit, when set, is told of
+// every claim and an error from it undoes the lot.
+func (mr *MemReservations) reserve(ops []*btcwire.OutPoint, commit func(key string, at time.Time) error) (bool, error) {
+	for _, op := range ops {
+		if mr.held(outPointStr(op)) {
+			return false, nil
+		}
+	}
+	now := time.Now()
+	for i, op := range ops {
+		key := outPointStr(op)
+		mr.reserved[key] = now
+		if commit == nil {
+			continue
+		}
+		if err := commit(key, now); err != nil {
+			for _, prev := range ops[:i+1] {
+				delete(mr.reserved, outPointStr(prev))
+			}
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func (mr *MemReservations) reserveSelected(unspents []*TxInParams, choose Chooser, commit func(key string, at time.Time) error) ([]*TxInParams, error) {
+	available := make([]*TxInParams, 0, len(unspents))
+	for _, utxo := range unspents {
+		if !mr.held(outPointStr(utxo.OutPoint)) {
+			available = append(available, utxo)
+		}
+	}
+	chosen, err := choose(available)
+	if err != nil {
+		return nil, err
+	}
+
+	ops := make([]*btcwire.OutPoint, len(chosen))
+	for i, utxo := range chosen {
+		ops[i] = utxo.OutPoint
+	}
+	ok, err := mr.reserve(ops, commit)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errors.New("Chooser picked an unspent that is already reserved")
+	}
+	return chosen, nil
+}
+
+func expired(at time.Time, ttl time.Duration) bool {
+	return ttl > 0 && time.Since(at) > ttl
+}
+
+// A journalEntry is a line in a FileReservations journal.
+type journalEntry struct {
+	OutPoint string    `json:"outpoint"`
+	Time     time.Time `json:"time"`
+	Release  bool      `json:"release,omitempty"`
+}
+
+// FileReservations keeps reservations in an append only json journal so that
+// they survive restarts. The journal is compacted every time it is opened.
+type FileReservations struct {
+	*MemReservations
+	path    string
+	journal *os.File
+}
+
+// OpenFileReservations replays the journal at path, creating it if needed.
+func OpenFileReservations(path string, ttl time.Duration) (*FileReservations, error) {
+	fr := FileReservations{
+		MemReservations: NewMemReservations(ttl),
+		path:            path,
+	}
+
+	f, err := os.Open(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			var entry journalEntry
+			if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+				f.Close()
+				return nil, err
+			}
+			if entry.Release {
+				delete(fr.reserved, entry.OutPoint)
+			} else {
+				fr.reserved[entry.OutPoint] = entry.Time
+			}
+		}
+		f.Close()
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := fr.compact(); err != nil {
+		return nil, err
+	}
+	return &fr, nil
+}
+
+// compact rewrites the journal with only the live reservations.
+func (fr *FileReservations) compact() error {
+	tmpPath := fr.path + ".tmp"
+	tmp, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(tmp)
+	for op, at := range fr.reserved {
+		if expired(at, fr.TTL) {
+			delete(fr.reserved, op)
+			continue
+		}
+		if err := enc.Encode(journalEntry{OutPoint: op, Time: at}); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, fr.path); err != nil {
+		return err
+	}
+
+	fr.journal, err = os.OpenFile(fr.path, os.O_APPEND|os.O_WRONLY, 0600)
+	return err
+}
+
+func (fr *FileReservations) record(entry journalEntry) error {
+	raw, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if _, err := fr.journal.Write(append(raw, '\n')); err != nil {
+		return err
+	}
+	return fr.journal.Sync()
+}
+
+// recordClaim journals a new reservation.
+func (fr *FileReservations) recordClaim(key string, at time.Time) error {
+	return fr.record(journalEntry{OutPoint: key, Time: at})
+}
+
+func (fr *FileReservations) ReserveSelected(unspents []*TxInParams, choose Chooser) ([]*TxInParams, error) {
+	fr.mtx.Lock()
+	defer fr.mtx.Unlock()
+	return fr.reserveSelected(unspents, choose, fr.recordClaim)
+}
+
+func (fr *FileReservations) Reserve(op *btcwire.OutPoint) (bool, error) {
+	fr.mtx.Lock()
+	defer fr.mtx.Unlock()
+	return fr.reserve([]*btcwire.OutPoint{op}, fr.recordClaim)
+}
+
+func (fr *FileReservations) Release(op *btcwire.OutPoint) error {
+	fr.mtx.Lock()
+	defer fr.mtx.Unlock()
+	key := outPointStr(op)
+	delete(fr.reserved, key)
+	return fr.record(journalEntry{OutPoint: key, Time: time.Now(), Release: true})
+}
+
+// Close closes the journal.
+func (fr *FileReservations) Close() error {
+	fr.mtx.Lock()
+	defer fr.mtx.Unlock()
+	return fr.journal.Close()
+}
+
+// noReservations is used by params without a store. Nothing is ever held.
+type noReservations struct{}
+
+func (noReservations) ReserveSelected(unspents []*TxInParams, choose Chooser) ([]*TxInParams, error) {
+	return choose(unspents)
+}
+
+func (noReservations) Reserve(*btcwire.OutPoint) (bool, error) { return true, nil }
+func (noReservations) Reserved(*btcwire.OutPoint) bool         { return false }
+func (noReservations) Release(*btcwire.OutPoint) error         { return nil }
+
+// reservations returns the params' ReservationStore.
+func (params BuilderParams) reservations() ReservationStore {
+	if params.Reservations == nil {
+		return noReservations{}
+	}
+	return params.Reservations
+}
+
+// reserveOuts claims unspents a caller picked by hand, all of them or none.
+func reserveOuts(outs []*TxInParams, params BuilderParams) error {
+	pick := func(available []*TxInParams) ([]*TxInParams, error) {
+		free := make(map[string]bool, len(available))
+		for _, utxo := range available {
+			free[outPointStr(utxo.OutPoint)] = true
+		}
+		for _, utxo := range outs {
+			if !free[outPointStr(utxo.OutPoint)] {
+				return nil, fmt.Errorf("Unspent %s is already reserved", outPointStr(utxo.OutPoint))
+			}
+		}
+		return available, nil
+	}
+	_, err := params.reservations().ReserveSelected(outs, pick)
+	return err
+}
@@ -0,0 +1,182 @@
+package btcbuilder
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sync"
+
+	"github.com/conformal/btcec"
+	"github.com/conformal/btcjson"
+	"github.com/conformal/btcnet"
+	"github.com/conformal/btcrpcclient"
+	"github.com/conformal/btcscript"
+	"github.com/conformal/btcutil"
+	"github.com/conformal/btcwire"
+)
+
+// A Signer produces the signature scripts for a transaction's inputs so that
+// builders never have to hold private keys themselves.
+type Signer interface {
+	// Sign returns the signature script for input idx of tx which spends a
+	// txout locked by pkScript.
+	Sign(tx *btcwire.MsgTx, idx int, pkScript []byte, hashType btcscript.SigHashType) ([]byte, error)
+}
+
+// KeyStore is an in process Signer that holds its keys in memory.
+type KeyStore struct {
+	NetParams *btcnet.Params
+	mtx       sync.RWMutex
+	keys      map[string]*btcutil.WIF // keyed by encoded address
+	scripts   map[string][]byte       // redeem scripts keyed by encoded P2SH address
+}
+
+func NewKeyStore(net *btcnet.Params) *KeyStore {
+	ks := KeyStore{
+		NetParams: net,
+		keys:      make(map[string]*btcutil.WIF),
+		scripts:   make(map[string][]byte),
+	}
+	return &ks
+}
+
+// AddKey stores wifkey and returns the pay to pubkey hash address it controls.
+func (ks *KeyStore) AddKey(wifkey *btcutil.WIF) (btcutil.Address, error) {
+	addr, err := wifToAddr(wifkey, ks.NetParams)
+	if err != nil {
+		return nil, err
+	}
+	ks.mtx.Lock()
+	ks.keys[addr.EncodeAddress()] = wifkey
+	ks.mtx.Unlock()
+	return addr, nil
+}
+
+// AddScript stores redeemScript so the KeyStore can sign for txouts paying
+// its P2SH address, which is returned.
+func (ks *KeyStore) AddScript(redeemScript []byte) (btcutil.Address, error) {
+	addr, err := btcutil.NewAddressScriptHash(redeemScript, ks.NetParams)
+	if err != nil {
+		return nil, err
+	}
+	ks.mtx.Lock()
+	ks.scripts[addr.EncodeAddress()] = redeemScript
+	ks.mtx.Unlock()
+	return addr, nil
+}
+
+// Key looks up the key that signs for pkScript.
+func (ks *KeyStore) Key(pkScript []byte) (*btcutil.WIF, error) {
+	addr, err := scriptAddr(pkScript, ks.NetParams)
+	if err != nil {
+		return nil, err
+	}
+	return ks.lookup(addr)
+}
+
+func (ks *KeyStore) lookup(addr btcutil.Address) (*btcutil.WIF, error) {
+	ks.mtx.RLock()
+	defer ks.mtx.RUnlock()
+	wifkey, ok := ks.keys[addr.EncodeAddress()]
+	if !ok {
+		return nil, fmt.Errorf("No key for address: %s", addr.EncodeAddress())
+	}
+	return wifkey, nil
+}
+
+func (ks *KeyStore) Sign(tx *btcwire.MsgTx, idx int, pkScript []byte, hashType btcscript.SigHashType) ([]byte, error) {
+	lookup := func(addr btcutil.Address) (*btcec.PrivateKey, bool, error) {
+		wifkey, err := ks.lookup(addr)
+		if err != nil {
+			return nil, false, err
+		}
+		return wifkey.PrivKey, wifkey.CompressPubKey, nil
+	}
+	script := func(addr btcutil.Address) ([]byte, error) {
+		ks.mtx.RLock()
+		defer ks.mtx.RUnlock()
+		redeemScript, ok := ks.scripts[addr.EncodeAddress()]
+		if !ok {
+			return nil, fmt.Errorf("No redeem script for address: %s", addr.EncodeAddress())
+		}
+		return redeemScript, nil
+	}
+	return btcscript.SignTxOutput(ks.NetParams, tx, idx, pkScript, hashType,
+		btcscript.KeyClosure(lookup), btcscript.ScriptClosure(script), nil)
+}
+
+// SourceSigner signs with keys it fetches from a UtxoSource as they are needed.
+// Backed by an RPCSource this is the dumpprivkey flow.
+type SourceSigner struct {
+	Source UtxoSource
+}
+
+func NewSourceSigner(src UtxoSource) *SourceSigner {
+	return &SourceSigner{Source: src}
+}
+
+func (ss *SourceSigner) Sign(tx *btcwire.MsgTx, idx int, pkScript []byte, hashType btcscript.SigHashType) ([]byte, error) {
+	wifkey, err := ss.Source.Key(pkScript)
+	if err != nil {
+		return nil, err
+	}
+	return btcscript.SignatureScript(tx, idx, pkScript, hashType, wifkey.PrivKey, wifkey.CompressPubKey)
+}
+
+// WalletSigner delegates signing to bitcoind with signrawtransaction, so private
+// keys never leave the wallet process.
+type WalletSigner struct {
+	Client *btcrpcclient.Client
+}
+
+func NewWalletSigner(client *btcrpcclient.Client) *WalletSigner {
+	return &WalletSigner{Client: client}
+}
+
+func (ws *WalletSigner) Sign(tx *btcwire.MsgTx, idx int, pkScript []byte, hashType btcscript.SigHashType) ([]byte, error) {
+	rpcHashType, err := walletHashType(hashType)
+	if err != nil {
+		return nil, err
+	}
+
+	prevOut := tx.TxIn[idx].PreviousOutPoint
+	input := btcjson.RawTxInput{
+		Txid:         prevOut.Hash.String(),
+		Vout:         prevOut.Index,
+		ScriptPubKey: hex.EncodeToString(pkScript),
+	}
+	// The wallet reports the tx as incomplete until every input is signed so
+	// that flag is ignored here.
+	signed, _, err := ws.Client.SignRawTransaction4(tx, []btcjson.RawTxInput{input}, nil, rpcHashType)
+	if err != nil {
+		return nil, err
+	}
+
+	scriptSig := signed.TxIn[idx].SignatureScript
+	if len(scriptSig) == 0 {
+		return nil, fmt.Errorf("Wallet could not sign input %d", idx)
+	}
+	return scriptSig, nil
+}
+
+// walletHashType maps a btcscript sighash flag onto the string form signrawtransaction expects.
+func walletHashType(hashType btcscript.SigHashType) (btcrpcclient.SigHashType, error) {
+	anyoneCanPay := hashType&btcscript.SigHashAnyOneCanPay != 0
+	switch hashType &^ btcscript.SigHashAnyOneCanPay {
+	case btcscript.SigHashAll:
+		if anyoneCanPay {
+			return btcrpcclient.SigHashAllAnyoneCanPay, nil
+		}
+		return btcrpcclient.SigHashAll, nil
+	case btcscript.SigHashNone:
+		if anyoneCanPay {
+			return btcrpcclient.SigHashNoneAnyoneCanPay, nil
+		}
+		return btcrpcclient.SigHashNone, nil
+	case btcscript.SigHashSingle:
+		if anyoneCanPay {
+			return btcrpcclient.SigHashSingleAnyoneCanPay, nil
+		}
+		return btcrpcclient.SigHashSingle, nil
+	}
+	return "", fmt.Errorf("Unknown sighash type: %d", hashType)
+}
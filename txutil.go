@@ -2,9 +2,6 @@ package btcbuilder
 
 import (
 	"bytes"
-	"crypto/ecdsa"
-	"crypto/elliptic"
-	"crypto/rand"
 	"encoding/hex"
 	_ "encoding/json"
 	"errors"
@@ -17,88 +14,14 @@ import (
 	"github.com/conformal/btcscript"
 	"github.com/conformal/btcutil"
 	"github.com/conformal/btcwire"
-	"github.com/jessevdk/go-flags"
 )
 
 var pver = btcwire.ProtocolVersion
 
-// Network specific config
-var magic btcwire.BitcoinNet
-
 // Everything you need to spend from a txout in the UTXO
 type TxInParams struct {
 	TxOut    *btcwire.TxOut
 	OutPoint *btcwire.OutPoint
-	Wif      *btcutil.WIF
-}
-
-type BitcoinConf struct {
-	RPCPassword string `long:"rpcpassword"`
-	RPCUser     string `long:"rpcuser"`
-	Testnet     bool   `long:"testnet" default:"false"`
-	RPCListen   bool   `long:"server" default:"true"`
-}
-
-/*
-	ConfigureApp assumes that you have a "bitcoin.conf" like ini file under the
-	bitcoin data dir. If so it will build you an http rpc client and all the network
-	parameters needed to configure your entire bitcoin based application. WARNING!
-	This can and will die on you if it detects errors.
-*/
-func ConfigureApp() (*btcrpcclient.Client, btcnet.Params) {
-	connCfg, testnet, err := CfgFromFile()
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	client, err := makeRpcClient(connCfg)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	var params btcnet.Params
-	if testnet {
-		params = btcnet.TestNet3Params
-	} else {
-		params = btcnet.MainNetParams
-	}
-
-	return client, params
-}
-
-func CfgFromFile() (*btcrpcclient.ConnConfig, bool, error) {
-	fileconf := &BitcoinConf{}
-
-	path := btcutil.AppDataDir("bitcoin", false) + "/bitcoin.conf"
-
-	parser := flags.NewParser(fileconf, flags.IgnoreUnknown)
-	err := flags.NewIniParser(parser).ParseFile(path)
-	if err != nil {
-		return nil, false, err
-	}
-
-	if !fileconf.RPCListen {
-		return nil, false, errors.New("Bitcoind not listening for rpc commands")
-	}
-
-	// TODO make rpcaddr configurable
-	var rpcaddr string
-	if fileconf.Testnet {
-		rpcaddr = "127.0.0.1:18332"
-	} else {
-		rpcaddr = "127.0.0.1:8332"
-	}
-
-	// TODO use tls!
-	connCfg := &btcrpcclient.ConnConfig{
-		Host:         rpcaddr,
-		User:         fileconf.RPCUser,
-		Pass:         fileconf.RPCPassword,
-		HttpPostMode: true,
-		DisableTLS:   true,
-	}
-
-	return connCfg, fileconf.Testnet, nil
 }
 
 func NetParamsFromStr(name string) (*btcnet.Params, error) {
@@ -139,57 +62,49 @@ func checkconnection(client *btcrpcclient.Client) error {
 	return nil
 }
 
-func rpcTxPick(exact bool, targetAmnt int64, params BuilderParams) (*TxInParams, error) {
-	// selects an unspent outpoint that is funded over the minAmount
-	list, err := params.Client.ListUnspent()
+// txPick selects an unspent outpoint from the params' UtxoSource that is funded
+// over (or exactly at) the targetAmnt
+func txPick(exact bool, targetAmnt int64, params BuilderParams) (*TxInParams, error) {
+	list, err := params.source().ListUnspent()
 	if err != nil {
 		log.Println("list unpsent threw")
 		return nil, err
 	}
+	list = fundingUnspents(list)
 	if len(list) < 1 {
 		return nil, errors.New("No unspent outputs at all.")
 	}
 
-	for _, prevJson := range list {
-		_amnt, _ := btcutil.NewAmount(prevJson.Amount)
-		amnt := int64(_amnt)
-		txid := prevJson.TxId
-		prevHash, _ := btcwire.NewShaHashFromStr(txid)
-		outPoint := btcwire.NewOutPoint(prevHash, prevJson.Vout)
-
-		_, contained := params.PendingSet[outPointStr(outPoint)]
-		// This unpsent is in the pending set and it either exactly equals the target or
-		// has a value above that target
-		if !contained && (exact && targetAmnt == amnt || !exact && targetAmnt <= amnt) {
-			// Found one, lets use it
-			script, _ := hex.DecodeString(prevJson.ScriptPubKey)
-			// None of the above ~should~ ever throw errors
-			txOut := btcwire.NewTxOut(amnt, script)
-
-			prevAddress, _ := btcutil.DecodeAddress(prevJson.Address, params.NetParams)
-			wifkey, err := params.Client.DumpPrivKey(prevAddress)
-			if err != nil {
-				return nil, err
-			}
-			inParams := TxInParams{
-				TxOut:    txOut,
-				OutPoint: outPoint,
-				Wif:      wifkey,
+	pick := func(available []*TxInParams) ([]*TxInParams, error) {
+		for _, utxo := range available {
+			amnt := utxo.TxOut.Value
+
+			// This unpsent either exactly equals the target or has a value above
+			// that target.
+			if exact && targetAmnt == amnt || !exact && targetAmnt <= amnt {
+				// Found one, lets use it
+				return []*TxInParams{utxo}, nil
 			}
-			params.PendingSet[outPointStr(outPoint)] = struct{}{}
-			return &inParams, nil
 		}
+		// Never found a good outpoint
+		return nil, errors.New("No txout with the right funds")
+	}
+
+	// Picking and reserving happen as one step so that builders in other
+	// goroutines cannot grab the same unspent.
+	chosen, err := params.reservations().ReserveSelected(list, pick)
+	if err != nil {
+		return nil, err
 	}
-	// Never found a good outpoint
-	return nil, errors.New("No txout with the right funds")
+	return chosen[0], nil
 }
 
 // specificUnspent gets an unspent output with an exact amount associated with it.
-// it throws an error otherwise. It will also check to see if the tx selected is in the
-// the pending tx set. If it is it will not use the txout
+// it throws an error otherwise. It will also check to see if the txout selected is
+// reserved. If it is it will not use the txout
 func specificUnspent(targetAmnt int64, params BuilderParams) (*TxInParams, error) {
 	exact := true
-	out, err := rpcTxPick(exact, targetAmnt, params)
+	out, err := txPick(exact, targetAmnt, params)
 	return out, err
 }
 
@@ -197,30 +112,92 @@ func specificUnspent(targetAmnt int64, params BuilderParams) (*TxInParams, error
 // Exactly similar to specific unspent except the operator is >=
 func selectUnspent(minAmount int64, params BuilderParams) (*TxInParams, error) {
 	exact := false
-	out, err := rpcTxPick(exact, minAmount, params)
+	out, err := txPick(exact, minAmount, params)
 	return out, err
 }
 
 // composeUnspents Builds out a set of TxInParams that can be used to spend minAmount of bitcoin
+// The params' CoinSelector decides which of the unspents not already reserved get used.
 func composeUnspents(minAmount int64, params BuilderParams) ([]*TxInParams, int64, error) {
-	// Arbitrary constant!
-	maxIns := 50
+	list, err := params.source().ListUnspent()
+	if err != nil {
+		return nil, 0, err
+	}
+	list = fundingUnspents(list)
+
+	selector := params.selector()
+	pick := func(available []*TxInParams) ([]*TxInParams, error) {
+		return selector.Select(available, minAmount)
+	}
+	inParamSet, err := params.reservations().ReserveSelected(list, pick)
+	if err != nil {
+		return nil, 0, err
+	}
+	return inParamSet, sumInputs(inParamSet), nil
+}
 
-	totalIn := int64(0)
-	inParamSet := make([]*TxInParams, 0)
-	for i := 0; i < maxIns; i++ {
-		txInParam, err := selectUnspent(minAmount/20, params)
+// composeFunded composes inputs worth what need says a tx spending them
+// requires. Every input grows the fee, so it keeps composing until the inputs
+// cover the needs of the tx they end up in.
+func composeFunded(need func(prevScripts [][]byte) int64, params BuilderParams) ([]*TxInParams, int64, error) {
+	target := need(p2pkhIns(1))
+	for i := 0; ; i++ {
+		inParamSet, totalIn, err := composeUnspents(target, params)
 		if err != nil {
-			return nil, totalIn, err
+			return nil, 0, err
 		}
-		inParamSet = append(inParamSet, txInParam)
-		totalIn += txInParam.TxOut.Value
-		if totalIn >= minAmount {
+		needed := need(inScripts(inParamSet))
+		if totalIn >= needed {
 			return inParamSet, totalIn, nil
 		}
+		releaseUnspents(inParamSet, params)
+		if i >= maxFeeRounds {
+			return nil, 0, errors.New("Fee never converged on the inputs composed")
+		}
+		target = needed
 	}
-	msg := fmt.Sprintf("Do not have enough coins to compose input: %d, from %d", minAmount, totalIn)
-	return inParamSet, 0, errors.New(msg)
+}
+
+// fundingUnspents drops the unspents builders cannot fund a tx with. Bare
+// multisig txouts need more than one signature so they are left to a
+// MultiSigSpender.
+func fundingUnspents(list []*TxInParams) []*TxInParams {
+	funding := make([]*TxInParams, 0, len(list))
+	for _, utxo := range list {
+		if btcscript.GetScriptClass(utxo.TxOut.PkScript) != btcscript.MultiSigTy {
+			funding = append(funding, utxo)
+		}
+	}
+	return funding
+}
+
+// releaseUnspents returns unspents to the pool once a build gives up on them
+func releaseUnspents(inParamSet []*TxInParams, params BuilderParams) {
+	store := params.reservations()
+	for _, inpParam := range inParamSet {
+		if inpParam == nil {
+			continue
+		}
+		if err := store.Release(inpParam.OutPoint); err != nil && params.Logger != nil {
+			params.Logger.Printf("Could not release %s: %s\n", outPointStr(inpParam.OutPoint), err)
+		}
+	}
+}
+
+// releaseErr releases inParamSet and passes err through so builders can give
+// back their inputs on the way out of a failed Build.
+func releaseErr(err error, params BuilderParams, inParamSet ...*TxInParams) error {
+	releaseUnspents(inParamSet, params)
+	return err
+}
+
+// releaseTx releases the outpoints msgtx spends, for when the node refuses it.
+func releaseTx(msgtx *btcwire.MsgTx, params BuilderParams) {
+	inParamSet := make([]*TxInParams, len(msgtx.TxIn))
+	for i, txin := range msgtx.TxIn {
+		inParamSet[i] = &TxInParams{OutPoint: &txin.PreviousOutPoint}
+	}
+	releaseUnspents(inParamSet, params)
 }
 
 // toHex converts a msgTx into a hex string.
@@ -250,6 +227,20 @@ func sumOutputs(tx *btcwire.MsgTx) (val int64) {
 	return val
 }
 
+// signInputs fills in the signature script of every input in msgtx with the
+// params' Signer. inParamSet must line up with msgtx.TxIn.
+func signInputs(msgtx *btcwire.MsgTx, inParamSet []*TxInParams, hashType btcscript.SigHashType, params BuilderParams) error {
+	signer := params.signer()
+	for i, inpParam := range inParamSet {
+		scriptSig, err := signer.Sign(msgtx, i, inpParam.TxOut.PkScript, hashType)
+		if err != nil {
+			return err
+		}
+		msgtx.TxIn[i].SignatureScript = scriptSig
+	}
+	return nil
+}
+
 func sumInputs(inParamSet []*TxInParams) (val int64) {
 	val = 0
 	for _, inpParam := range inParamSet {
@@ -259,25 +250,24 @@ func sumInputs(inParamSet []*TxInParams) (val int64) {
 }
 
 func newWifKeyPair(net *btcnet.Params) *btcutil.WIF {
-	curve := elliptic.P256()
-	priv, _ := ecdsa.GenerateKey(curve, rand.Reader)
-	wif, _ := btcutil.NewWIF((*btcec.PrivateKey)(priv), net, true)
+	priv, _ := btcec.NewPrivateKey(btcec.S256())
+	wif, _ := btcutil.NewWIF(priv, net, true)
 	return wif
 }
 
-func wifToAddr(wifkey *btcutil.WIF, net *btcnet.Params) btcutil.Address {
+func wifToAddr(wifkey *btcutil.WIF, net *btcnet.Params) (btcutil.Address, error) {
 	pubkey := wifkey.SerializePubKey()
 	pkHash := btcutil.Hash160(pubkey)
 	addr, err := btcutil.NewAddressPubKeyHash(pkHash, net)
 	if err != nil {
-		log.Fatalf("failed to convert wif to address: %s\n", err)
+		return nil, fmt.Errorf("failed to convert wif to address: %s", err)
 	}
-	return addr
+	return addr, nil
 }
 
-// Gets a new address from an rpc client
-func newAddr(client *btcrpcclient.Client) (btcutil.Address, error) {
-	addr, err := client.GetNewAddress()
+// Gets a new address from the params' UtxoSource
+func newAddr(params BuilderParams) (btcutil.Address, error) {
+	addr, err := params.source().NewAddr()
 	if err != nil {
 		return nil, err
 	}
@@ -314,9 +304,43 @@ func outPointStr(outpoint *btcwire.OutPoint) string {
 	return fmt.Sprintf("%s[%d]", outpoint.Hash.String(), outpoint.Index)
 }
 
+// sweepTx spends all of inParamSet to addr. sign fills in every input and is
+// run twice, since the fee is taken from the size of a signed tx.
+func sweepTx(inParamSet []*TxInParams, addr btcutil.Address, sign func(*btcwire.MsgTx) error, params BuilderParams) (*btcwire.MsgTx, error) {
+	msgtx := btcwire.NewMsgTx()
+	for _, inpParam := range inParamSet {
+		msgtx.AddTxIn(btcwire.NewTxIn(inpParam.OutPoint, []byte{}))
+	}
+	script, err := btcscript.PayToAddrScript(addr)
+	if err != nil {
+		return nil, err
+	}
+	total := sumInputs(inParamSet)
+	txout := btcwire.NewTxOut(total, script)
+	msgtx.AddTxOut(txout)
+
+	if err := sign(msgtx); err != nil {
+		return nil, err
+	}
+	// A signature can come out a byte longer the second time
+	fee := params.fee(msgtx.SerializeSize() + len(msgtx.TxIn))
+	txout.Value = total - fee
+	if txout.Value < params.DustAmnt {
+		return nil, fmt.Errorf("Inputs worth %d do not cover the fee of %d", total, fee)
+	}
+	if err := sign(msgtx); err != nil {
+		return nil, err
+	}
+
+	if err := params.verify(msgtx, inParamSet...); err != nil {
+		return nil, err
+	}
+	return msgtx, nil
+}
+
 func makeChange(changeAmnt int64, params BuilderParams) (*btcwire.TxOut, error) {
 	// Change needed
-	changeAddr, err := params.Client.GetNewAddress()
+	changeAddr, err := newAddr(params)
 	if err != nil {
 		return nil, err
 	}
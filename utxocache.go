@@ -0,0 +1,190 @@
+package btcbuilder
+
+import (
+	"sync"
+
+	"github.com/conformal/btcscript"
+	"github.com/conformal/btcutil"
+	"github.com/conformal/btcwire"
+)
+
+// A TxApplier is a UtxoSource that can be told about transactions before the
+// node has seen them. Builders can then spend the txouts of a tx that has not
+// been broadcast.
+type TxApplier interface {
+	UtxoSource
+	// Apply drops the unspents msgtx spends and adds the txouts it pays to
+	// keys the source holds. Applying the same tx twice changes nothing.
+	Apply(msgtx *btcwire.MsgTx)
+	// Revert undoes Apply for a tx the node never accepted. Txs that spend
+	// msgtx's txouts have to be reverted first.
+	Revert(msgtx *btcwire.MsgTx)
+}
+
+// UtxoCache sits in front of a UtxoSource and lists its unspents once. After
+// that the snapshot is kept current locally: Apply removes what a tx spends
+// and adds the txouts it pays back to us. Refresh goes back to the source.
+type UtxoCache struct {
+	Source UtxoSource
+
+	mtx      sync.Mutex
+	loaded   bool
+	unspents []*TxInParams
+	spent    map[btcwire.OutPoint]bool
+	dropped  map[btcwire.OutPoint]*TxInParams // What Apply took out, for Revert
+	mine     map[string]bool                  // pkScripts we can spend, keyed by the raw script
+}
+
+func NewUtxoCache(src UtxoSource) *UtxoCache {
+	cache := UtxoCache{
+		Source:  src,
+		spent:   make(map[btcwire.OutPoint]bool),
+		dropped: make(map[btcwire.OutPoint]*TxInParams),
+		mine:    make(map[string]bool),
+	}
+	return &cache
+}
+
+// Refresh replaces the snapshot with a fresh listing from the source.
+func (cache *UtxoCache) Refresh() error {
+	cache.mtx.Lock()
+	defer cache.mtx.Unlock()
+	return cache.refresh()
+}
+
+func (cache *UtxoCache) refresh() error {
+	list, err := cache.Source.ListUnspent()
+	if err != nil {
+		return err
+	}
+	cache.unspents = list
+	cache.spent = make(map[btcwire.OutPoint]bool)
+	cache.dropped = make(map[btcwire.OutPoint]*TxInParams)
+	for _, utxo := range list {
+		cache.mine[string(utxo.TxOut.PkScript)] = true
+	}
+	cache.loaded = true
+	return nil
+}
+
+// ListUnspent returns copies of the snapshot, loading it on first use.
+func (cache *UtxoCache) ListUnspent() ([]*TxInParams, error) {
+	cache.mtx.Lock()
+	defer cache.mtx.Unlock()
+
+	if !cache.loaded {
+		if err := cache.refresh(); err != nil {
+			return nil, err
+		}
+	}
+	unspents := make([]*TxInParams, 0, len(cache.unspents))
+	for _, utxo := range cache.unspents {
+		inParams := *utxo
+		unspents = append(unspents, &inParams)
+	}
+	return unspents, nil
+}
+
+func (cache *UtxoCache) Key(pkScript []byte) (*btcutil.WIF, error) {
+	return cache.Source.Key(pkScript)
+}
+
+// NewAddr gets an address from the source and remembers it so that txouts
+// paying it are picked up by Apply.
+func (cache *UtxoCache) NewAddr() (btcutil.Address, error) {
+	addr, err := cache.Source.NewAddr()
+	if err != nil {
+		return nil, err
+	}
+	script, err := btcscript.PayToAddrScript(addr)
+	if err != nil {
+		return nil, err
+	}
+	cache.mtx.Lock()
+	cache.mine[string(script)] = true
+	cache.mtx.Unlock()
+	return addr, nil
+}
+
+// Apply updates the snapshot as if msgtx had been accepted: the outpoints it
+// spends are dropped and its txouts to our addresses become unspents.
+func (cache *UtxoCache) Apply(msgtx *btcwire.MsgTx) {
+	cache.mtx.Lock()
+	defer cache.mtx.Unlock()
+
+	mine := func(pkScript []byte) bool {
+		return cache.mine[string(pkScript)]
+	}
+	cache.unspents = applyToUnspents(cache.unspents, cache.spent, cache.dropped, msgtx, mine)
+}
+
+// Revert puts back what Apply changed for msgtx.
+func (cache *UtxoCache) Revert(msgtx *btcwire.MsgTx) {
+	cache.mtx.Lock()
+	defer cache.mtx.Unlock()
+	cache.unspents = revertUnspents(cache.unspents, cache.spent, cache.dropped, msgtx)
+}
+
+// applyToUnspents returns unspents less the outpoints msgtx spends plus the
+// txouts of msgtx that are mine. Every spend is remembered in spent so that a
+// tx applied again cannot bring back a txout a later tx already used, and the
+// unspents taken out are kept in dropped for revertUnspents.
+func applyToUnspents(unspents []*TxInParams, spent map[btcwire.OutPoint]bool,
+	dropped map[btcwire.OutPoint]*TxInParams, msgtx *btcwire.MsgTx,
+	mine func(pkScript []byte) bool) []*TxInParams {
+
+	for _, txin := range msgtx.TxIn {
+		spent[txin.PreviousOutPoint] = true
+	}
+	have := make(map[btcwire.OutPoint]bool, len(unspents))
+	kept := make([]*TxInParams, 0, len(unspents)+len(msgtx.TxOut))
+	for _, utxo := range unspents {
+		if spent[*utxo.OutPoint] {
+			dropped[*utxo.OutPoint] = utxo
+			continue
+		}
+		kept = append(kept, utxo)
+		have[*utxo.OutPoint] = true
+	}
+
+	hash := btcutil.NewTx(msgtx).Sha()
+	for i, txout := range msgtx.TxOut {
+		outpoint := btcwire.NewOutPoint(hash, uint32(i))
+		if spent[*outpoint] || have[*outpoint] || !mine(txout.PkScript) {
+			continue
+		}
+		inParams := TxInParams{
+			TxOut:    txout,
+			OutPoint: outpoint,
+		}
+		kept = append(kept, &inParams)
+	}
+	return kept
+}
+
+// revertUnspents undoes applyToUnspents for msgtx. Its txouts are removed and
+// the unspents it spent come back out of dropped.
+func revertUnspents(unspents []*TxInParams, spent map[btcwire.OutPoint]bool,
+	dropped map[btcwire.OutPoint]*TxInParams, msgtx *btcwire.MsgTx) []*TxInParams {
+
+	hash := btcutil.NewTx(msgtx).Sha()
+	kept := make([]*TxInParams, 0, len(unspents)+len(msgtx.TxIn))
+	for _, utxo := range unspents {
+		if !hash.IsEqual(&utxo.OutPoint.Hash) {
+			kept = append(kept, utxo)
+		}
+	}
+	for i := range msgtx.TxOut {
+		delete(dropped, *btcwire.NewOutPoint(hash, uint32(i)))
+	}
+
+	for _, txin := range msgtx.TxIn {
+		op := txin.PreviousOutPoint
+		delete(spent, op)
+		if utxo, ok := dropped[op]; ok {
+			delete(dropped, op)
+			kept = append(kept, utxo)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,197 @@
+package btcbuilder
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"sync"
+
+	"github.com/conformal/btcnet"
+	"github.com/conformal/btcrpcclient"
+	"github.com/conformal/btcscript"
+	"github.com/conformal/btcutil"
+	"github.com/conformal/btcwire"
+)
+
+// A UtxoSource supplies the unspent txouts builders fund transactions with
+// along with the keys needed to spend them.
+type UtxoSource interface {
+	// ListUnspent returns every txout the source can spend.
+	ListUnspent() ([]*TxInParams, error)
+	// Key returns the private key that can sign for txouts locked by pkScript.
+	Key(pkScript []byte) (*btcutil.WIF, error)
+	// NewAddr returns a fresh address the source controls.
+	NewAddr() (btcutil.Address, error)
+}
+
+// RPCSource draws unspents and keys from a bitcoind wallet over rpc.
+type RPCSource struct {
+	Client    *btcrpcclient.Client
+	NetParams *btcnet.Params
+}
+
+func NewRPCSource(client *btcrpcclient.Client, net *btcnet.Params) *RPCSource {
+	src := RPCSource{
+		Client:    client,
+		NetParams: net,
+	}
+	return &src
+}
+
+func (src *RPCSource) ListUnspent() ([]*TxInParams, error) {
+	list, err := src.Client.ListUnspent()
+	if err != nil {
+		return nil, err
+	}
+
+	unspents := make([]*TxInParams, 0, len(list))
+	for _, prevJson := range list {
+		amnt, err := btcutil.NewAmount(prevJson.Amount)
+		if err != nil {
+			return nil, err
+		}
+		prevHash, err := btcwire.NewShaHashFromStr(prevJson.TxId)
+		if err != nil {
+			return nil, err
+		}
+		script, err := hex.DecodeString(prevJson.ScriptPubKey)
+		if err != nil {
+			return nil, err
+		}
+		inParams := TxInParams{
+			TxOut:    btcwire.NewTxOut(int64(amnt), script),
+			OutPoint: btcwire.NewOutPoint(prevHash, prevJson.Vout),
+		}
+		unspents = append(unspents, &inParams)
+	}
+	return unspents, nil
+}
+
+// Key asks the wallet to dump the private key of the address the unspent pays.
+func (src *RPCSource) Key(pkScript []byte) (*btcutil.WIF, error) {
+	addr, err := scriptAddr(pkScript, src.NetParams)
+	if err != nil {
+		return nil, err
+	}
+	return src.Client.DumpPrivKey(addr)
+}
+
+func (src *RPCSource) NewAddr() (btcutil.Address, error) {
+	return src.Client.GetNewAddress()
+}
+
+// MemSource is an in memory wallet. It holds a set of unspents and the keys
+// that sign for them so builders can run against fixtures without a node.
+// The embedded KeyStore makes it a Signer as well.
+type MemSource struct {
+	*KeyStore
+	mtx      sync.Mutex
+	unspents []*TxInParams
+	spent    map[btcwire.OutPoint]bool
+	dropped  map[btcwire.OutPoint]*TxInParams
+	nextFund uint64
+}
+
+func NewMemSource(net *btcnet.Params) *MemSource {
+	src := MemSource{
+		KeyStore: NewKeyStore(net),
+		unspents: make([]*TxInParams, 0),
+		spent:    make(map[btcwire.OutPoint]bool),
+		dropped:  make(map[btcwire.OutPoint]*TxInParams),
+	}
+	return &src
+}
+
+// AddUnspent makes the txout at outpoint spendable by builders. The source
+// must hold the key for the txout's address for the unspent to be signed.
+func (src *MemSource) AddUnspent(outpoint *btcwire.OutPoint, txout *btcwire.TxOut) {
+	inParams := TxInParams{
+		TxOut:    txout,
+		OutPoint: outpoint,
+	}
+	src.mtx.Lock()
+	src.unspents = append(src.unspents, &inParams)
+	src.mtx.Unlock()
+}
+
+// Fund creates a pay to pubkey hash unspent worth amnt under a fresh key. The
+// outpoint references a transaction that does not exist, so the result is only
+// good for building fixtures.
+func (src *MemSource) Fund(amnt int64) (*TxInParams, error) {
+	addr, err := src.NewAddr()
+	if err != nil {
+		return nil, err
+	}
+	script, err := btcscript.PayToAddrScript(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	src.mtx.Lock()
+	src.nextFund++
+	seed := make([]byte, 8)
+	binary.LittleEndian.PutUint64(seed, src.nextFund)
+	src.mtx.Unlock()
+	fakeHash, err := btcwire.NewShaHash(btcwire.DoubleSha256(seed))
+	if err != nil {
+		return nil, err
+	}
+	outpoint := btcwire.NewOutPoint(fakeHash, 0)
+	txout := btcwire.NewTxOut(amnt, script)
+	src.AddUnspent(outpoint, txout)
+
+	inParams := TxInParams{
+		TxOut:    txout,
+		OutPoint: outpoint,
+	}
+	return &inParams, nil
+}
+
+func (src *MemSource) ListUnspent() ([]*TxInParams, error) {
+	src.mtx.Lock()
+	defer src.mtx.Unlock()
+	unspents := make([]*TxInParams, 0, len(src.unspents))
+	for _, utxo := range src.unspents {
+		inParams := *utxo
+		unspents = append(unspents, &inParams)
+	}
+	return unspents, nil
+}
+
+// Apply spends msgtx's inputs out of the source and adds the txouts the
+// source holds keys for.
+func (src *MemSource) Apply(msgtx *btcwire.MsgTx) {
+	src.mtx.Lock()
+	defer src.mtx.Unlock()
+
+	mine := func(pkScript []byte) bool {
+		_, err := src.Key(pkScript)
+		return err == nil
+	}
+	src.unspents = applyToUnspents(src.unspents, src.spent, src.dropped, msgtx, mine)
+}
+
+// Revert puts back what Apply changed for msgtx.
+func (src *MemSource) Revert(msgtx *btcwire.MsgTx) {
+	src.mtx.Lock()
+	defer src.mtx.Unlock()
+	src.unspents = revertUnspents(src.unspents, src.spent, src.dropped, msgtx)
+}
+
+// NewAddr generates a new key that the source will remember.
+func (src *MemSource) NewAddr() (btcutil.Address, error) {
+	wifkey := newWifKeyPair(src.NetParams)
+	return src.AddKey(wifkey)
+}
+
+// scriptAddr pulls the single address out of a standard pkScript.
+func scriptAddr(pkScript []byte, net *btcnet.Params) (btcutil.Address, error) {
+	_, addrs, _, err := btcscript.ExtractPkScriptAddrs(pkScript, net)
+	if err != nil {
+		return nil, err
+	}
+	if len(addrs) != 1 {
+		return nil, errors.New("Txout does not pay to a single address")
+	}
+	return addrs[0], nil
+}
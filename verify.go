@@ -0,0 +1,49 @@
+package btcbuilder
+
+import (
+	"fmt"
+
+	"github.com/conformal/btcscript"
+	"github.com/conformal/btcwire"
+)
+
+// The script rules every built transaction is held to.
+const verifyFlags = btcscript.ScriptBip16 | btcscript.ScriptCanonicalSignatures
+
+// VerifyError names the input of a transaction that failed to validate.
+type VerifyError struct {
+	Index  int   // The index of the failing txin
+	Reason error // What the script engine objected to
+}
+
+func (e *VerifyError) Error() string {
+	return fmt.Sprintf("Input %d failed verification: %s", e.Index, e.Reason)
+}
+
+// VerifyTx runs every input of msgtx through the script engine against the
+// pkScript it spends. prevScripts must line up with msgtx.TxIn. The first
+// input that fails is returned as a *VerifyError.
+func VerifyTx(msgtx *btcwire.MsgTx, prevScripts [][]byte) error {
+	if len(prevScripts) != len(msgtx.TxIn) {
+		return fmt.Errorf("Have %d previous scripts for %d inputs", len(prevScripts), len(msgtx.TxIn))
+	}
+	for i, txin := range msgtx.TxIn {
+		engine, err := btcscript.NewScript(txin.SignatureScript, prevScripts[i], i, msgtx, verifyFlags)
+		if err != nil {
+			return &VerifyError{Index: i, Reason: err}
+		}
+		if err := engine.Execute(); err != nil {
+			return &VerifyError{Index: i, Reason: err}
+		}
+	}
+	return nil
+}
+
+// verify checks a freshly built msgtx that spends inParamSet unless the
+// params opted out of verification.
+func (params BuilderParams) verify(msgtx *btcwire.MsgTx, inParamSet ...*TxInParams) error {
+	if params.SkipVerify {
+		return nil
+	}
+	return VerifyTx(msgtx, inScripts(inParamSet))
+}